@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/dtls/v2"
+)
+
+// nackAccessDenied es el código de motivo de los NACK por falta de permisos.
+const nackAccessDenied = "ACCESS_DENIED"
+
+// Permisos de un archivo o carpeta, de menor a mayor: cada uno incluye a los
+// anteriores. En una carpeta, "rw" permite crear entradas dentro de ella.
+const (
+	permNone  = "none"
+	permRead  = "r"
+	permWrite = "rw"
+	permAdmin = "admin"
+)
+
+var permLevels = map[string]int{permNone: 0, "": 0, permRead: 1, permWrite: 2, permAdmin: 3}
+
+// FileACL son los permisos de una entrada. El dueño (CN de quien la creó)
+// siempre tiene permAdmin; el grupo y los demás tienen los permisos indicados,
+// y Grants da permisos a usuarios concretos por su CN.
+type FileACL struct {
+	Owner     string            `json:"owner"`
+	Group     string            `json:"group,omitempty"`
+	GroupPerm string            `json:"group_perm,omitempty"`
+	OtherPerm string            `json:"other_perm,omitempty"`
+	Grants    map[string]string `json:"grants,omitempty"`
+}
+
+// ACLChange es el payload de SET_ACL. Target es "group", "group:<nombre>"
+// (cambia además el grupo), "others" o "user:<CN>"; Perm "none" quita el
+// permiso de un usuario.
+type ACLChange struct {
+	FileName string `json:"file_name"`
+	Target   string `json:"target"`
+	Perm     string `json:"perm"`
+}
+
+// Identity es quien está al otro lado de una sesión DTLS, según su
+// certificado (verifyPeerCertificate ya lo validó con la CA en el handshake):
+// el CN es el usuario y las OU sus grupos. Los certificados de servidor
+// (ExtKeyUsageServerAuth) identifican a otros nodos, que ya comprobaron los
+// permisos del cliente original antes de reenviar la petición.
+type Identity struct {
+	Name   string
+	Groups []string
+	Server bool
+	Serial string // Número de serie del certificado, para la revocación.
+}
+
+// aclAdminGroup es el grupo (OU) que administra las entradas sin ACL. Se fija
+// con -acl-admin-group.
+var aclAdminGroup = "admins"
+
+// caPool es el conjunto de CAs con que se verifican los certificados de los
+// peers y clientes. Se carga en main.
+var caPool *x509.CertPool
+
+func (id Identity) String() string {
+	if id.Name == "" {
+		return "anónimo"
+	}
+	return id.Name
+}
+
+// peerIdentity obtiene la identidad del certificado del otro extremo. Si no
+// hay certificado la sesión es anónima, aunque el servidor exige uno en el
+// handshake.
+func peerIdentity(conn net.Conn) Identity {
+	dtlsConn, ok := conn.(*dtls.Conn)
+	if !ok {
+		return Identity{}
+	}
+	rawCerts := dtlsConn.ConnectionState().PeerCertificates
+	if len(rawCerts) == 0 {
+		return Identity{}
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return Identity{}
+	}
+	id := Identity{Name: cert.Subject.CommonName, Groups: cert.Subject.OrganizationalUnit, Serial: cert.SerialNumber.String()}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			id.Server = true
+		}
+	}
+	return id
+}
+
+// inGroup indica si id pertenece a group.
+func (id Identity) inGroup(group string) bool {
+	for _, g := range id.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// allows indica si id tiene al menos el permiso need sobre una entrada con
+// estas ACL. Las entradas sin ACL (anteriores a los permisos, publicadas desde
+// -share-dir o creadas por sesiones anónimas) se pueden leer y escribir sin
+// restricción, pero sólo las administra aclAdminGroup: no tienen un dueño que
+// pueda cederlas.
+func (acl *FileACL) allows(id Identity, need string) bool {
+	if acl == nil {
+		return permLevels[need] < permLevels[permAdmin] || (id.Name != "" && id.inGroup(aclAdminGroup))
+	}
+	if id.Name != "" && id.Name == acl.Owner {
+		return true
+	}
+	level := permLevels[acl.OtherPerm]
+	if id.Name != "" && permLevels[acl.Grants[id.Name]] > level {
+		level = permLevels[acl.Grants[id.Name]]
+	}
+	if acl.Group != "" && permLevels[acl.GroupPerm] > level && id.inGroup(acl.Group) {
+		level = permLevels[acl.GroupPerm]
+	}
+	return level >= permLevels[need]
+}
+
+// newEntryACL arma las ACL de una entrada creada por id dentro de parent: el
+// creador queda como dueño y se heredan el grupo, los permisos y las
+// concesiones de la carpeta. En la raíz, o bajo una carpeta sin ACL, el grupo
+// es la primera OU del creador, que puede escribir, y los demás pueden leer.
+// Debe llamarse con sharedFilesMutex tomado.
+func newEntryACL(id Identity, parent string) *FileACL {
+	if id.Name == "" {
+		return nil
+	}
+	if dir, found := sharedFiles[parent]; found && dir.ACL != nil {
+		acl := &FileACL{Owner: id.Name, Group: dir.ACL.Group, GroupPerm: dir.ACL.GroupPerm, OtherPerm: dir.ACL.OtherPerm}
+		for user, perm := range dir.ACL.Grants {
+			if acl.Grants == nil {
+				acl.Grants = make(map[string]string)
+			}
+			acl.Grants[user] = perm
+		}
+		return acl
+	}
+	acl := &FileACL{Owner: id.Name, GroupPerm: permWrite, OtherPerm: permRead}
+	if len(id.Groups) > 0 {
+		acl.Group = id.Groups[0]
+	}
+	return acl
+}
+
+// entryAllows comprueba un permiso sobre name. Si name no existe no hay nada
+// que proteger y el handler responderá que no se encontró; la raíz ("") admite
+// que cualquiera cree entradas.
+func entryAllows(name string, id Identity, need string) bool {
+	if name == "" {
+		return true
+	}
+	sharedFilesMutex.RLock()
+	entry, found := sharedFiles[name]
+	sharedFilesMutex.RUnlock()
+	if !found || entry.Deleted {
+		return true
+	}
+	return entry.ACL.allows(id, need)
+}
+
+// accessDenied arma el NACK de una petición sin permiso.
+func accessDenied(localAddr, format string, args ...interface{}) *NetworkMessage {
+	return &NetworkMessage{
+		Type:          "NACK",
+		Reason:        nackAccessDenied,
+		Payload:       []byte(fmt.Sprintf(format, args...)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// authorize aplica las ACL a una petición de cliente antes de atenderla y
+// devuelve el NACK si id no tiene permiso, o nil. Las peticiones de otros
+// servidores no se comprueban: el nodo que recibió la petición del cliente ya
+// lo hizo. Los mensajes entre nodos y los tipos desconocidos sólo se aceptan de
+// un servidor.
+func authorize(msg NetworkMessage, id Identity, localAddr string) *NetworkMessage {
+	if id.Server {
+		return nil
+	}
+	var target, need string
+	var fileName string
+	switch msg.Type {
+	case "GET_FILE_INFO", "GET_FULL_LIST", "LIST_DIR", "GET_METRICS":
+		// Consultas del directorio, que no dan acceso al contenido.
+		return nil
+	case "REQUEST_FILE", "LIST_VERSIONS":
+		json.Unmarshal(msg.Payload, &fileName)
+		target, need = fileName, permRead
+	case "REQUEST_FILE_CHUNK":
+		var req FileChunkRequest
+		json.Unmarshal(msg.Payload, &req)
+		target, need = req.FileName, permRead
+	case "GET_VERSION":
+		var req VersionRequest
+		json.Unmarshal(msg.Payload, &req)
+		target, need = req.FileName, permRead
+	case "FILE_WRITE_UPDATE":
+		var update FileUpdate
+		json.Unmarshal(msg.Payload, &update)
+		target, need = update.FileName, permWrite
+	case "ROLLBACK":
+		var req VersionRequest
+		json.Unmarshal(msg.Payload, &req)
+		target, need = req.FileName, permWrite
+	case "DELETE_FILE":
+		json.Unmarshal(msg.Payload, &fileName)
+		target, need = fileName, permWrite
+	case "ACQUIRE_LOCK", "RENEW_LOCK", "RELEASE_LOCK":
+		var req LockRequest
+		json.Unmarshal(msg.Payload, &req)
+		target, need = req.FileName, permWrite
+	case "ADD_FILE", "MKDIR":
+		// Crear una entrada exige escribir en su carpeta; los nombres ya en uso
+		// los rechaza el handler.
+		json.Unmarshal(msg.Payload, &fileName)
+		target, need = parentDir(fileName), permWrite
+	case "MOVE":
+		var req MoveRequest
+		json.Unmarshal(msg.Payload, &req)
+		if !entryAllows(parentDir(req.To), id, permWrite) {
+			target, need = parentDir(req.To), permWrite
+		} else {
+			target, need = req.From, permWrite
+		}
+	case "SET_ACL":
+		var change ACLChange
+		json.Unmarshal(msg.Payload, &change)
+		target, need = change.FileName, permAdmin
+	default:
+		// GOSSIP_UPDATE, FILE_COPY_UPDATE, RUMOR, REPLICA_PUSH, SYNC_TREE,
+		// SYNC_BUCKET, JOIN, MEMBERSHIP, PING, PING_REQ, REQUEST_STATUS y los
+		// tipos que no se conocen: un cliente podría con ellos escribir
+		// entradas o contenido sin pasar por las ACL.
+		logEvent("ACL", "ACCESS_DENIED", fmt.Sprintf("%s rechazado para %s: sólo lo pueden enviar los servidores.", msg.Type, id))
+		return accessDenied(localAddr, "Permiso denegado: %s sólo lo pueden enviar los servidores.", msg.Type)
+	}
+	if entryAllows(target, id, need) {
+		return nil
+	}
+	logEvent("ACL", "ACCESS_DENIED", fmt.Sprintf("%s rechazado para %s: requiere '%s' sobre '%s'.", msg.Type, id, need, target))
+	return accessDenied(localAddr, "Permiso denegado: %s necesita '%s' sobre '/%s'.", id, need, target)
+}
+
+// handleSetACL atiende SET_ACL en el dueño de la entrada. authorize ya
+// comprobó que quien la pide puede administrarla.
+func handleSetACL(msg NetworkMessage, localAddr string, id Identity) NetworkMessage {
+	var change ACLChange
+	json.Unmarshal(msg.Payload, &change)
+	logEvent("ACL", "SET_ACL_REQUEST", fmt.Sprintf("%s pide '%s' para %s sobre '%s'.", id, change.Perm, change.Target, change.FileName))
+	if _, response := ownedEntry(change.FileName, localAddr); response != nil {
+		return *response
+	}
+	if _, valid := permLevels[change.Perm]; !valid || change.Perm == "" {
+		return namespaceNack(localAddr, "Permiso '%s' no reconocido (use none, r, rw o admin).", change.Perm)
+	}
+
+	sharedFilesMutex.Lock()
+	entry, found := sharedFiles[change.FileName]
+	if !found || entry.Deleted || entry.OwnerIP != selfAddr {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "'%s' cambió mientras se procesaba la petición.", change.FileName)
+	}
+	var acl FileACL
+	if entry.ACL != nil {
+		acl = *entry.ACL
+	} else {
+		// La primera ACL de una entrada sin ACL conserva el acceso libre y la
+		// deja en manos de aclAdminGroup, sin dueño: authorize sólo deja
+		// llegar aquí a sus miembros.
+		acl = FileACL{Group: aclAdminGroup, GroupPerm: permAdmin, OtherPerm: permWrite}
+	}
+	grants := make(map[string]string, len(acl.Grants))
+	for user, perm := range acl.Grants {
+		grants[user] = perm
+	}
+	acl.Grants = grants
+
+	switch {
+	case change.Target == "others":
+		acl.OtherPerm = change.Perm
+	case change.Target == "group":
+		acl.GroupPerm = change.Perm
+	case strings.HasPrefix(change.Target, "group:"):
+		acl.Group = strings.TrimPrefix(change.Target, "group:")
+		acl.GroupPerm = change.Perm
+	case strings.HasPrefix(change.Target, "user:") && len(change.Target) > len("user:"):
+		user := strings.TrimPrefix(change.Target, "user:")
+		if change.Perm == permNone {
+			delete(acl.Grants, user)
+		} else {
+			acl.Grants[user] = change.Perm
+		}
+	default:
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "Destinatario '%s' no reconocido (use group, group:<nombre>, others o user:<CN>).", change.Target)
+	}
+	if len(acl.Grants) == 0 {
+		acl.Grants = nil
+	}
+	entry.ACL = &acl
+	bumpVersion(&entry, selfAddr)
+	sharedFiles[change.FileName] = entry
+	dirStore.Put(entry)
+	sharedFilesMutex.Unlock()
+
+	logEvent("ACL", "ACL_UPDATED", fmt.Sprintf("Permisos de '%s' actualizados por %s: %s=%s.", change.FileName, id, change.Target, change.Perm))
+	go gossipProtocol.GossipUpdateAllPeers(entry)
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte(fmt.Sprintf("Permisos de '%s' actualizados.", change.FileName)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
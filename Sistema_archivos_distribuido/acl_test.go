@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func aclMessage(msgType string, payload interface{}) NetworkMessage {
+	payloadBytes, _ := json.Marshal(payload)
+	return NetworkMessage{Type: msgType, Payload: payloadBytes}
+}
+
+func TestAuthorize(t *testing.T) {
+	var (
+		alice  = Identity{Name: "alice", Groups: []string{"devs"}}
+		bob    = Identity{Name: "bob", Groups: []string{"devs"}}
+		carol  = Identity{Name: "carol", Groups: []string{"ops"}}
+		dave   = Identity{Name: "dave", Groups: []string{"admins"}}
+		server = Identity{Name: "127.0.0.1:9001", Server: true}
+	)
+	resetDirectory(
+		DirectoryEntry{FileName: "docs", IsDir: true, ACL: &FileACL{Owner: "alice", Group: "devs", GroupPerm: permWrite, OtherPerm: permRead}},
+		DirectoryEntry{FileName: "docs/a.txt", ACL: &FileACL{Owner: "alice", Group: "devs", GroupPerm: permWrite, OtherPerm: permRead}},
+		DirectoryEntry{FileName: "privado.txt", ACL: &FileACL{Owner: "alice", OtherPerm: permNone, Grants: map[string]string{"carol": permRead}}},
+		DirectoryEntry{FileName: "libre.txt"},
+	)
+	entry := DirectoryEntry{FileName: "docs/a.txt"}
+
+	tests := []struct {
+		name    string
+		id      Identity
+		msg     NetworkMessage
+		allowed bool
+	}{
+		{"listado", carol, aclMessage("GET_FULL_LIST", ""), true},
+		{"lectura de otros", carol, aclMessage("REQUEST_FILE", "docs/a.txt"), true},
+		{"escritura del grupo", bob, aclMessage("FILE_WRITE_UPDATE", FileUpdate{FileName: "docs/a.txt"}), true},
+		{"escritura de otros", carol, aclMessage("FILE_WRITE_UPDATE", FileUpdate{FileName: "docs/a.txt"}), false},
+		{"borrado de otros", carol, aclMessage("DELETE_FILE", "docs/a.txt"), false},
+		{"crear en carpeta ajena", carol, aclMessage("ADD_FILE", "docs/b.txt"), false},
+		{"crear en carpeta del grupo", bob, aclMessage("ADD_FILE", "docs/b.txt"), true},
+		{"mover a carpeta ajena", carol, aclMessage("MOVE", MoveRequest{From: "libre.txt", To: "docs/libre.txt"}), false},
+		{"ACL por el dueño", alice, aclMessage("SET_ACL", ACLChange{FileName: "docs/a.txt", Target: "others", Perm: permNone}), true},
+		{"ACL por el grupo", bob, aclMessage("SET_ACL", ACLChange{FileName: "docs/a.txt", Target: "others", Perm: permNone}), false},
+		{"concesión de lectura", carol, aclMessage("REQUEST_FILE", "privado.txt"), true},
+		{"sin concesión", bob, aclMessage("REQUEST_FILE", "privado.txt"), false},
+		{"sin ACL se escribe", carol, aclMessage("FILE_WRITE_UPDATE", FileUpdate{FileName: "libre.txt"}), true},
+		{"sin ACL no se adueña", carol, aclMessage("SET_ACL", ACLChange{FileName: "libre.txt", Target: "others", Perm: permNone}), false},
+		{"sin ACL administra el grupo admin", dave, aclMessage("SET_ACL", ACLChange{FileName: "libre.txt", Target: "others", Perm: permRead}), true},
+		{"anónimo sin ACL", Identity{}, aclMessage("SET_ACL", ACLChange{FileName: "libre.txt", Target: "others", Perm: permRead}), false},
+		{"GOSSIP_UPDATE de cliente", alice, aclMessage("GOSSIP_UPDATE", entry), false},
+		{"FILE_COPY_UPDATE de cliente", alice, aclMessage("FILE_COPY_UPDATE", entry), false},
+		{"RUMOR de cliente", alice, aclMessage("RUMOR", entry), false},
+		{"REPLICA_PUSH de cliente", alice, aclMessage("REPLICA_PUSH", entry), false},
+		{"SYNC_TREE de cliente", alice, aclMessage("SYNC_TREE", nil), false},
+		{"SYNC_BUCKET de cliente", alice, aclMessage("SYNC_BUCKET", nil), false},
+		{"JOIN de cliente", alice, aclMessage("JOIN", "127.0.0.1:9999"), false},
+		{"MEMBERSHIP de cliente", alice, aclMessage("MEMBERSHIP", nil), false},
+		{"PING de cliente", alice, aclMessage("PING", nil), false},
+		{"PING_REQ de cliente", alice, aclMessage("PING_REQ", nil), false},
+		{"REQUEST_STATUS de cliente", alice, aclMessage("REQUEST_STATUS", "docs/a.txt"), false},
+		{"tipo desconocido", alice, aclMessage("NO_EXISTE", nil), false},
+		{"GOSSIP_UPDATE de servidor", server, aclMessage("GOSSIP_UPDATE", entry), true},
+		{"escritura reenviada por servidor", server, aclMessage("FILE_WRITE_UPDATE", FileUpdate{FileName: "docs/a.txt"}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denied := authorize(tt.msg, tt.id, "127.0.0.1:9000")
+			if (denied == nil) != tt.allowed {
+				t.Fatalf("authorize(%s, %s) = %v; permitido esperado: %v", tt.msg.Type, tt.id, denied, tt.allowed)
+			}
+			if denied != nil && denied.Reason != nackAccessDenied {
+				t.Errorf("motivo %q, se esperaba %q", denied.Reason, nackAccessDenied)
+			}
+		})
+	}
+}
+
+func TestNewEntryACL(t *testing.T) {
+	resetDirectory(DirectoryEntry{FileName: "docs", IsDir: true, ACL: &FileACL{Owner: "alice", Group: "devs", GroupPerm: permRead, Grants: map[string]string{"carol": permWrite}}})
+	bob := Identity{Name: "bob", Groups: []string{"devs"}}
+
+	inherited := newEntryACL(bob, "docs")
+	if inherited.Owner != "bob" || inherited.Group != "devs" || inherited.GroupPerm != permRead || inherited.Grants["carol"] != permWrite {
+		t.Errorf("ACL heredada = %+v", inherited)
+	}
+	inherited.Grants["carol"] = permNone
+	if sharedFiles["docs"].ACL.Grants["carol"] != permWrite {
+		t.Error("modificar la ACL heredada cambió la de la carpeta")
+	}
+
+	root := newEntryACL(bob, "")
+	if root.Owner != "bob" || root.Group != "devs" || root.GroupPerm != permWrite || root.OtherPerm != permRead {
+		t.Errorf("ACL en la raíz = %+v", root)
+	}
+	if newEntryACL(Identity{}, "") != nil {
+		t.Error("una sesión anónima no debe dejar dueño")
+	}
+}
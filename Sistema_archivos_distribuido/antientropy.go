@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Anti-entropía con un árbol de Merkle sobre el directorio. Cada entrada cae en
+// una hoja según los dos primeros dígitos hexadecimales del hash de su nombre
+// (256 hojas); cada nodo interno resume a sus 16 hijos. En cada ronda los peers
+// comparan la raíz y sólo descienden por las ramas que difieren, de modo que
+// con directorios iguales basta un mensaje y sólo se envían las entradas de las
+// hojas distintas.
+const (
+	merkleFanout = 16
+	merkleDepth  = 2 // Dígitos hexadecimales del prefijo de una hoja.
+)
+
+// SyncTreeNode es el payload de SYNC_TREE: la petición lleva sólo Prefix y la
+// respuesta el hash del nodo y los de sus hijos.
+type SyncTreeNode struct {
+	Prefix   string   `json:"prefix"`
+	Hash     string   `json:"hash,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// SyncBucket es el payload de SYNC_BUCKET: las entradas de una hoja. Quien
+// inicia envía las suyas y recibe las del peer, así ambos convergen en un paso.
+type SyncBucket struct {
+	Prefix  string                    `json:"prefix"`
+	Entries map[string]DirectoryEntry `json:"entries"`
+}
+
+// merkleTree guarda los hashes de todos los nodos del árbol por prefijo.
+type merkleTree map[string]string
+
+// entryBucket devuelve el prefijo de la hoja que corresponde a fileName.
+func entryBucket(fileName string) string {
+	sum := sha256.Sum256([]byte(fileName))
+	return hex.EncodeToString(sum[:])[:merkleDepth]
+}
+
+// entryDigest resume lo que decide mergeEntry: el reloj y si es lápida. El TTL
+// no entra, porque cada nodo lo descuenta por su cuenta.
+func entryDigest(entry DirectoryEntry) string {
+	clockBytes, _ := json.Marshal(entryClock(entry))
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%t", entry.FileName, clockBytes, entry.Deleted)))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashParts combina hashes en uno. Un nodo vacío tiene hash vacío.
+func hashParts(parts []string) string {
+	empty := true
+	for _, part := range parts {
+		if part != "" {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// childPrefixes devuelve los prefijos de los hijos de un nodo.
+func childPrefixes(prefix string) []string {
+	children := make([]string, merkleFanout)
+	for i := range children {
+		children[i] = prefix + fmt.Sprintf("%x", i)
+	}
+	return children
+}
+
+// buildMerkleTree calcula el árbol del directorio local. Se llama con
+// sharedFilesMutex tomado al menos en lectura.
+func buildMerkleTree() merkleTree {
+	leaves := make(map[string][]string)
+	for _, entry := range sharedFiles {
+		bucket := entryBucket(entry.FileName)
+		leaves[bucket] = append(leaves[bucket], entryDigest(entry))
+	}
+	tree := make(merkleTree)
+	var fill func(prefix string) string
+	fill = func(prefix string) string {
+		var hash string
+		if len(prefix) == merkleDepth {
+			digests := leaves[prefix]
+			sort.Strings(digests)
+			hash = hashParts(digests)
+		} else {
+			var childHashes []string
+			for _, child := range childPrefixes(prefix) {
+				childHashes = append(childHashes, fill(child))
+			}
+			hash = hashParts(childHashes)
+		}
+		tree[prefix] = hash
+		return hash
+	}
+	fill("")
+	return tree
+}
+
+// node devuelve el nodo del árbol con los hashes de sus hijos.
+func (tree merkleTree) node(prefix string) SyncTreeNode {
+	node := SyncTreeNode{Prefix: prefix, Hash: tree[prefix]}
+	if len(prefix) < merkleDepth {
+		for _, child := range childPrefixes(prefix) {
+			node.Children = append(node.Children, tree[child])
+		}
+	}
+	return node
+}
+
+// bucketEntries devuelve las entradas locales de una hoja. Se llama con
+// sharedFilesMutex tomado al menos en lectura.
+func bucketEntries(prefix string) map[string]DirectoryEntry {
+	entries := make(map[string]DirectoryEntry)
+	for name, entry := range sharedFiles {
+		if entryBucket(name) == prefix {
+			entries[name] = entry
+		}
+	}
+	return entries
+}
+
+// mergeBucket aplica las entradas recibidas de una hoja y devuelve cuántas
+// cambiaron el directorio local.
+func mergeBucket(entries map[string]DirectoryEntry, source string) int {
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	changed := 0
+	for fileName, entry := range entries {
+		if mergeEntry(entry) {
+			changed++
+			logEvent("GOSSIP_ROUTINE", "MERGE_UPDATE", fmt.Sprintf("Actualización de chismorreo para '%s' con versión %d desde %s", fileName, entry.Version, source))
+		}
+	}
+	return changed
+}
+
+// handleSyncTree atiende SYNC_TREE con los hashes del nodo pedido.
+func handleSyncTree(msg NetworkMessage, localAddr string) NetworkMessage {
+	var request SyncTreeNode
+	json.Unmarshal(msg.Payload, &request)
+	if len(request.Prefix) > merkleDepth {
+		return NetworkMessage{Type: "NACK", Payload: []byte("Prefijo de árbol inválido.")}
+	}
+	sharedFilesMutex.RLock()
+	tree := buildMerkleTree()
+	sharedFilesMutex.RUnlock()
+	payloadBytes, _ := json.Marshal(tree.node(request.Prefix))
+	return NetworkMessage{
+		Type:          "SYNC_TREE",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleSyncBucket atiende SYNC_BUCKET: toma las entradas del peer y le
+// devuelve las propias de esa hoja, tal como estaban antes de la fusión.
+func handleSyncBucket(msg NetworkMessage, localAddr string) NetworkMessage {
+	var incoming SyncBucket
+	json.Unmarshal(msg.Payload, &incoming)
+	if len(incoming.Prefix) != merkleDepth {
+		return NetworkMessage{Type: "NACK", Payload: []byte("Prefijo de hoja inválido.")}
+	}
+	sharedFilesMutex.RLock()
+	local := SyncBucket{Prefix: incoming.Prefix, Entries: bucketEntries(incoming.Prefix)}
+	sharedFilesMutex.RUnlock()
+	mergeBucket(incoming.Entries, msg.SenderIP)
+
+	payloadBytes, _ := json.Marshal(local)
+	return NetworkMessage{
+		Type:          "SYNC_BUCKET",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// AntiEntropy sincroniza el directorio con peerAddr comparando árboles de
+// Merkle. Todas las peticiones de la ronda van por la misma sesión DTLS.
+// Devuelve cuántas hojas difirieron.
+func (gp *GossipProtocol) AntiEntropy(peerAddr string) (int, error) {
+	conn, err := gp.connectToPeer(peerAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	exchange := func(msgType string, payload interface{}) (NetworkMessage, error) {
+		payloadBytes, _ := json.Marshal(payload)
+		if err := writeMessage(conn, NetworkMessage{Type: msgType, Payload: payloadBytes, SenderIP: gp.selfAddr}); err != nil {
+			return NetworkMessage{}, fmt.Errorf("falla al enviar %s a %s: %v", msgType, peerAddr, err)
+		}
+		setReadDeadline(conn, time.Now().Add(10*time.Second))
+		response, err := readMessage(conn)
+		if err != nil {
+			return NetworkMessage{}, fmt.Errorf("falla al leer respuesta de %s: %v", peerAddr, err)
+		}
+		if response.Type != msgType {
+			return NetworkMessage{}, fmt.Errorf("respuesta inesperada de %s: %s", peerAddr, response.Type)
+		}
+		return response, nil
+	}
+
+	sharedFilesMutex.RLock()
+	tree := buildMerkleTree()
+	sharedFilesMutex.RUnlock()
+
+	var differing []string
+	pending := []string{""}
+	for len(pending) > 0 {
+		prefix := pending[0]
+		pending = pending[1:]
+		response, err := exchange("SYNC_TREE", SyncTreeNode{Prefix: prefix})
+		if err != nil {
+			return len(differing), err
+		}
+		var remote SyncTreeNode
+		json.Unmarshal(response.Payload, &remote)
+		if remote.Hash == tree[prefix] {
+			continue
+		}
+		for i, child := range childPrefixes(prefix) {
+			if i >= len(remote.Children) || remote.Children[i] == tree[child] {
+				continue
+			}
+			if len(child) == merkleDepth {
+				differing = append(differing, child)
+			} else {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	for _, prefix := range differing {
+		sharedFilesMutex.RLock()
+		local := SyncBucket{Prefix: prefix, Entries: bucketEntries(prefix)}
+		sharedFilesMutex.RUnlock()
+		response, err := exchange("SYNC_BUCKET", local)
+		if err != nil {
+			return len(differing), err
+		}
+		var remote SyncBucket
+		json.Unmarshal(response.Payload, &remote)
+		mergeBucket(remote.Entries, peerAddr)
+	}
+	return len(differing), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// aclUsage es la ayuda de los comandos de permisos. Los permisos son none, r,
+// rw y admin.
+var aclUsage = map[string]string{
+	"chmod": "chmod <nombre> <group|group:<grupo>|others> <none|r|rw|admin>",
+	"share": "share <nombre> <usuario> <none|r|rw|admin>",
+}
+
+// printACL muestra los permisos de una entrada.
+func printACL(acl *FileACL) {
+	if acl == nil {
+		fmt.Println("Permisos: acceso libre (sin ACL)")
+		return
+	}
+	if acl.Owner != "" {
+		fmt.Printf("Propietario: %s\n", acl.Owner)
+	} else {
+		fmt.Println("Propietario: - (la administra su grupo)")
+	}
+	if acl.Group != "" {
+		fmt.Printf("Grupo: %s (%s)\n", acl.Group, permOrNone(acl.GroupPerm))
+	} else {
+		fmt.Printf("Grupo: - (%s)\n", permOrNone(acl.GroupPerm))
+	}
+	fmt.Printf("Otros: %s\n", permOrNone(acl.OtherPerm))
+	if len(acl.Grants) > 0 {
+		users := make([]string, 0, len(acl.Grants))
+		for user := range acl.Grants {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+		grants := make([]string, 0, len(users))
+		for _, user := range users {
+			grants = append(grants, user+"="+acl.Grants[user])
+		}
+		fmt.Printf("Compartido con: %s\n", strings.Join(grants, ", "))
+	}
+}
+
+func permOrNone(perm string) string {
+	if perm == "" {
+		return "none"
+	}
+	return perm
+}
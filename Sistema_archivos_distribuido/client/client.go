@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -56,9 +57,14 @@ var (
 	knownServers   = []string{"192.168.100.136:8080"} // Lista de servidores a los que intentará conectarse
 	localDirectory = make(map[string]DirectoryEntry)
 	mu             sync.Mutex // Mutex para proteger localDirectory
+	stdinReader    = bufio.NewReader(os.Stdin)
 )
 
-func getDTLSConfig() (*dtls.Config, error) {
+// getDTLSConfig arma la configuración DTLS para conectarse a serverAddr y exige
+// que el certificado del servidor haya sido emitido para su host. pion/dtls no
+// comprueba ServerName cuando es una IP, así que el host se verifica también en
+// VerifyPeerCertificate, después de que pion validó la cadena con la CA.
+func getDTLSConfig(serverAddr string) (*dtls.Config, error) {
 	caCert, err := os.ReadFile("ca.crt")
 	if err != nil {
 		return nil, fmt.Errorf("falla al cargar certificado de la CA: %v", err)
@@ -70,22 +76,43 @@ func getDTLSConfig() (*dtls.Config, error) {
 
 	cert, err := tls.LoadX509KeyPair("client.crt", "client.key")
 	if err != nil {
-		return nil, fmt.Errorf("falla al cargar el par de claves: %v", err)
+		return nil, fmt.Errorf("falla al cargar el par de claves: %v (emítalo con 'go run ./dfs-ca issue-client -name <usuario>')", err)
 	}
 
+	host, _, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		host = serverAddr
+	}
 	return &dtls.Config{
 		Certificates:         []tls.Certificate{cert},
 		RootCAs:              roots,
+		ServerName:           host,
 		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerHost(rawCerts, host)
+		},
 	}, nil
 }
 
-func connectToPeer() (*dtls.Conn, error) {
-	dtlsConfig, err := getDTLSConfig()
+// verifyServerHost comprueba que el certificado del servidor fue emitido para
+// host (un nombre DNS o una IP de sus SAN).
+func verifyServerHost(rawCerts [][]byte, host string) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("el servidor no presentó certificado")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("certificado del servidor malformado: %v", err)
 	}
+	return cert.VerifyHostname(host)
+}
+
+func connectToPeer() (*dtls.Conn, error) {
 	for _, addr := range knownServers {
+		dtlsConfig, err := getDTLSConfig(addr)
+		if err != nil {
+			return nil, err
+		}
 		udpAddr, err := net.ResolveUDPAddr("udp", addr)
 		if err != nil {
 			logEvent("CLIENT", "CONNECTION_ATTEMPT", fmt.Sprintf("Falla al resolver dirección %s: %v", addr, err))
@@ -102,24 +129,14 @@ func connectToPeer() (*dtls.Conn, error) {
 }
 
 func sendMessage(conn *dtls.Conn, msg NetworkMessage) (NetworkMessage, error) {
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		return NetworkMessage{}, fmt.Errorf("fallo al serializar el mensaje: %v", err)
-	}
-	_, err = conn.Write(msgBytes)
-	if err != nil {
+	if err := writeMessage(conn, msg); err != nil {
 		return NetworkMessage{}, fmt.Errorf("fallo al escribir en la conexión: %v", err)
 	}
-	buffer := make([]byte, 4096)
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	n, err := conn.Read(buffer)
+	setReadDeadline(conn, time.Now().Add(10*time.Second))
+	responseMsg, err := readMessage(conn)
 	if err != nil {
 		return NetworkMessage{}, fmt.Errorf("fallo al leer de la conexión: %v", err)
 	}
-	var responseMsg NetworkMessage
-	if err := json.Unmarshal(buffer[:n], &responseMsg); err != nil {
-		return NetworkMessage{}, fmt.Errorf("fallo al deserializar la respuesta: %v", err)
-	}
 	return responseMsg, nil
 }
 
@@ -140,8 +157,8 @@ func getEditor() string {
 }
 
 func printMenu() {
-	fmt.Println("Comandos: list, get <nombre>, add <nombre>, edit <nombre>, view <nombre>, exit")
-	fmt.Print("-> ")
+	fmt.Println("Comandos: list, ls [carpeta], cd <carpeta>, mkdir <carpeta>, mv <origen> <destino>, chmod <nombre> <group|group:<grupo>|others> <permiso>, share <nombre> <usuario> <permiso>, get <nombre>, add <nombre>, edit <nombre>, view <nombre>, delete <nombre>, history <nombre>, revert <nombre> <versión>, exit")
+	fmt.Printf("%s -> ", displayPath(cwd))
 }
 
 func processResponse(responseMsg NetworkMessage) {
@@ -155,6 +172,13 @@ func processResponse(responseMsg NetworkMessage) {
 		logEvent("CLIENT", "RESPONSE_LIST_RECEIVED", fmt.Sprintf("Lista de %d archivos recibida y guardada localmente.", len(localDirectory)))
 		fmt.Println("\n--- Archivos Compartidos (Actualizados) ---")
 		for name, entry := range localDirectory {
+			if entry.Deleted {
+				continue
+			}
+			if entry.IsDir {
+				fmt.Printf("- Carpeta: %s/, Dueño: %s\n", name, entry.OwnerIP)
+				continue
+			}
 			fmt.Printf("- Nombre: %s, Tamaño: %d bytes, Dueño: %s, Versión: %d\n", name, entry.Size, entry.OwnerIP, entry.Version)
 		}
 		fmt.Println("----------------------------------------\n")
@@ -172,7 +196,11 @@ func processResponse(responseMsg NetworkMessage) {
 		fmt.Printf("Tamaño: %d bytes\n", entry.Size)
 		fmt.Printf("Fecha de Modificación: %s\n", entry.ModificationDate)
 		fmt.Printf("Dueño: %s\n", entry.OwnerIP)
+		if len(entry.Replicas) > 0 {
+			fmt.Printf("Réplicas: %s\n", strings.Join(entry.Replicas, ", "))
+		}
 		fmt.Printf("Versión: %d\n", entry.Version)
+		printACL(entry.ACL)
 		fmt.Println("---------------------------\n")
 		printMenu()
 
@@ -190,8 +218,31 @@ func processResponse(responseMsg NetworkMessage) {
 		fmt.Println("❌ Servidor:", string(responseMsg.Payload))
 		printMenu()
 
+	case "UPDATE_CONFLICT_COPY":
+		fmt.Println("⚠️  Servidor:", string(responseMsg.Payload))
+		printMenu()
+
+	case "RESPONSE_VERSIONS":
+		var history []VersionRecord
+		json.Unmarshal(responseMsg.Payload, &history)
+		fmt.Println("\n--- Historial de versiones ---")
+		for i := len(history) - 1; i >= 0; i-- {
+			record := history[i]
+			fmt.Printf("- Versión: %d, Tamaño: %d bytes, Modificado: %s, SHA-256: %.12s\n", record.Version, record.Size, record.ModificationDate.Format("2006-01-02 15:04:05"), record.Hash)
+		}
+		fmt.Println("------------------------------")
+		printMenu()
+
 	case "NACK":
-		fmt.Println("❌ Servidor:", string(responseMsg.Payload))
+		if responseMsg.Reason != "" {
+			fmt.Printf("❌ Servidor [%s]: %s\n", responseMsg.Reason, string(responseMsg.Payload))
+		} else {
+			fmt.Println("❌ Servidor:", string(responseMsg.Payload))
+		}
+		printMenu()
+
+	case "REDIRECT_OWNER":
+		fmt.Println("↪️  El archivo pertenece a", string(responseMsg.Payload))
 		printMenu()
 
 	default:
@@ -211,9 +262,10 @@ func executeAndProcess(conn *dtls.Conn, msg NetworkMessage) {
 	processResponse(responseMsg)
 }
 
+// main inicia el cliente. Ejecutar con: go run client.go structs.go framing.go transfer.go redirect.go namespace.go acl.go
 func main() {
 	var currentConn *dtls.Conn
-	
+
 	// Intenta la conexión inicial.
 	conn, err := connectToPeer()
 	if err != nil {
@@ -221,9 +273,9 @@ func main() {
 		return
 	}
 	currentConn = conn
-	defer currentConn.Close()
+	defer func() { currentConn.Close() }()
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := stdinReader
 	fmt.Println("Cliente de Directorio Distribuido - Modo CLI")
 	printMenu()
 
@@ -233,18 +285,70 @@ func main() {
 		parts := strings.SplitN(input, " ", 2)
 		command := parts[0]
 
-		var fileName string
+		// Los nombres se resuelven contra la carpeta actual (cwd).
+		var arg, fileName string
 		if len(parts) >= 2 {
-			fileName = parts[1]
+			arg = strings.TrimSpace(parts[1])
+			fileName = resolvePath(arg)
 		}
 
 		var msg NetworkMessage
 
 		switch command {
 		case "list":
-			msg = NetworkMessage{Type: "GET_FULL_LIST"}
+			cwdBytes, _ := json.Marshal(cwd)
+			msg = NetworkMessage{Type: "GET_FULL_LIST", Payload: cwdBytes}
 			executeAndProcess(currentConn, msg)
-		
+
+		case "ls":
+			if arg == "" {
+				fileName = cwd
+			}
+			listDirectory(currentConn, fileName)
+
+		case "cd":
+			changeDirectory(currentConn, fileName)
+
+		case "mkdir":
+			if arg == "" {
+				fmt.Println("Uso: mkdir <carpeta>")
+				printMenu()
+				continue
+			}
+			fileNameBytes, _ := json.Marshal(fileName)
+			msg = NetworkMessage{Type: "MKDIR", Payload: fileNameBytes}
+			executeAndProcess(currentConn, msg)
+
+		case "mv":
+			paths := strings.Fields(arg)
+			if len(paths) != 2 {
+				fmt.Println("Uso: mv <origen> <destino>")
+				printMenu()
+				continue
+			}
+			from, to := resolvePath(paths[0]), resolvePath(paths[1])
+			payloadBytes, _ := json.Marshal(MoveRequest{From: from, To: to})
+			msg = NetworkMessage{Type: "MOVE", Payload: payloadBytes}
+			executeOnOwner(currentConn, from, msg)
+
+		case "chmod", "share":
+			// Los dos últimos argumentos son el destinatario y el permiso; el
+			// nombre puede contener espacios.
+			fields := strings.Fields(arg)
+			if len(fields) < 3 {
+				fmt.Printf("Uso: %s\n", aclUsage[command])
+				printMenu()
+				continue
+			}
+			target := fields[len(fields)-2]
+			if command == "share" {
+				target = "user:" + target
+			}
+			name := resolvePath(strings.Join(fields[:len(fields)-2], " "))
+			payloadBytes, _ := json.Marshal(ACLChange{FileName: name, Target: target, Perm: fields[len(fields)-1]})
+			msg = NetworkMessage{Type: "SET_ACL", Payload: payloadBytes}
+			executeOnOwner(currentConn, name, msg)
+
 		case "get":
 			if len(parts) < 2 {
 				fmt.Println("Uso: get <nombre_archivo>")
@@ -264,16 +368,58 @@ func main() {
 			fileNameBytes, _ := json.Marshal(fileName)
 			msg = NetworkMessage{Type: "ADD_FILE", Payload: fileNameBytes}
 			executeAndProcess(currentConn, msg)
-			
+
+		case "delete":
+			if len(parts) < 2 {
+				fmt.Println("Uso: delete <nombre_archivo>")
+				printMenu()
+				continue
+			}
+			fileNameBytes, _ := json.Marshal(fileName)
+			msg = NetworkMessage{Type: "DELETE_FILE", Payload: fileNameBytes}
+			executeOnOwner(currentConn, fileName, msg)
+
+		case "history":
+			if len(parts) < 2 {
+				fmt.Println("Uso: history <nombre_archivo>")
+				printMenu()
+				continue
+			}
+			fileNameBytes, _ := json.Marshal(fileName)
+			msg = NetworkMessage{Type: "LIST_VERSIONS", Payload: fileNameBytes}
+			executeOnOwner(currentConn, fileName, msg)
+
+		case "revert":
+			// La versión es la última palabra; el nombre puede contener espacios.
+			sep := strings.LastIndex(fileName, " ")
+			var version int64
+			if sep > 0 {
+				version, err = strconv.ParseInt(fileName[sep+1:], 10, 64)
+			}
+			if sep <= 0 || err != nil {
+				fmt.Println("Uso: revert <nombre_archivo> <versión>")
+				printMenu()
+				continue
+			}
+			payloadBytes, _ := json.Marshal(VersionRequest{FileName: fileName[:sep], Version: version})
+			msg = NetworkMessage{Type: "ROLLBACK", Payload: payloadBytes}
+			executeOnOwner(currentConn, fileName[:sep], msg)
+
 		case "view":
 			if len(parts) < 2 {
 				fmt.Println("Uso: view <nombre_archivo>")
 				printMenu()
 				continue
 			}
-			fileNameBytes, _ := json.Marshal(fileName)
-			msg = NetworkMessage{Type: "REQUEST_FILE", Payload: fileNameBytes}
-			executeAndProcess(currentConn, msg)
+			var content []byte
+			content, currentConn, err = downloadFile(currentConn, fileName)
+			if err != nil {
+				logEvent("CLIENT", "DOWNLOAD_ERROR", fmt.Sprintf("Falla al descargar '%s': %v", fileName, err))
+				fmt.Println("❌", err)
+				printMenu()
+				continue
+			}
+			processResponse(NetworkMessage{Type: "FILE_RESPONSE", Payload: content})
 
 		case "edit":
 			if len(parts) < 2 {
@@ -281,8 +427,8 @@ func main() {
 				printMenu()
 				continue
 			}
-			handleEditFlow(currentConn, fileName, reader)
-			
+			currentConn = handleEditFlow(currentConn, fileName, reader)
+
 		case "exit":
 			logEvent("CLIENT", "EXIT", "Cerrando cliente.")
 			return
@@ -295,79 +441,178 @@ func main() {
 }
 
 // handleEditFlow gestiona la secuencia de pasos para la edición de archivos.
-func handleEditFlow(conn *dtls.Conn, fileName string, reader *bufio.Reader) {
+// Devuelve la conexión vigente, que puede cambiar si la descarga tuvo que reconectar.
+func handleEditFlow(conn *dtls.Conn, fileName string, reader *bufio.Reader) *dtls.Conn {
 	// 1. OBTENER INFORMACIÓN DE VERSIÓN (usando GET_FILE_INFO)
 	fileNameBytes, _ := json.Marshal(fileName)
 	infoMsg := NetworkMessage{Type: "GET_FILE_INFO", Payload: fileNameBytes}
 	infoResponse, err := sendMessage(conn, infoMsg)
 	if err != nil || infoResponse.Type != "RESPONSE" {
 		fmt.Println("❌ Error al obtener información del archivo para edición. Intente LIST primero.")
-		return
+		return conn
 	}
 	var entry DirectoryEntry
 	json.Unmarshal(infoResponse.Payload, &entry)
 
-	// 2. OBTENER CONTENIDO DEL ARCHIVO (usando REQUEST_FILE)
-	requestFileMsg := NetworkMessage{Type: "REQUEST_FILE", Payload: fileNameBytes}
-	fileResponse, err := sendMessage(conn, requestFileMsg)
-	if err != nil || fileResponse.Type != "FILE_RESPONSE" {
-		fmt.Println("❌ Error al descargar el contenido del archivo.")
-		return
+	// 2. CANDADO EXCLUSIVO: nadie más puede editar mientras dure la unidad de trabajo.
+	lockToken, err := acquireLock(conn, fileName)
+	if err != nil {
+		fmt.Println("❌", err)
+		return conn
 	}
-	
-	// 3. UNIT OF WORK: Guardar, Editar y Leer.
-	tempFile := "edit_" + fileName
-	if err := os.WriteFile(tempFile, fileResponse.Payload, 0644); err != nil {
+	defer func() { releaseLock(conn, fileName, lockToken) }()
+
+	// 3. OBTENER CONTENIDO DEL ARCHIVO (por fragmentos verificados)
+	content, conn, err := downloadFile(conn, fileName)
+	if err != nil {
+		logEvent("CLIENT", "DOWNLOAD_ERROR", fmt.Sprintf("Falla al descargar '%s': %v", fileName, err))
+		fmt.Println("❌ Error al descargar el contenido del archivo:", err)
+		return conn
+	}
+
+	// 4. UNIT OF WORK: Guardar, Editar y Leer.
+	tempFile := "edit_" + localName(fileName)
+	if err := os.WriteFile(tempFile, content, 0644); err != nil {
 		logEvent("CLIENT", "FILE_ERROR", fmt.Sprintf("Falla al guardar archivo temporal: %v", err))
-		return
+		return conn
 	}
-	
+
 	fmt.Printf("Archivo descargado y guardado como '%s'.\n", tempFile)
 	fmt.Printf(">>> Por favor, edita el archivo y presiona Enter para subir los cambios. <<<\n")
 
-	// Ejecutar editor externo
-	editor := getEditor()
-	cmd := exec.Command(editor, tempFile)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	if err := cmd.Run(); err != nil {
-		logEvent("CLIENT", "EDITOR_ERROR", fmt.Sprintf("Error al ejecutar el editor: %v", err))
-		os.Remove(tempFile)
-		return
+	// El reloj base es el de la versión descargada; si el servidor devuelve
+	// conflictos de fusión, pasa a ser el de la versión con la que se fusionó.
+	baseVersion, baseClock := entry.Version, entry.Clock
+	for {
+		// Ejecutar editor externo
+		editor := getEditor()
+		cmd := exec.Command(editor, tempFile)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		stopRenewal := renewLockWhileEditing(conn, fileName, lockToken)
+		err := cmd.Run()
+		stopRenewal()
+		if err != nil {
+			logEvent("CLIENT", "EDITOR_ERROR", fmt.Sprintf("Error al ejecutar el editor: %v", err))
+			os.Remove(tempFile)
+			return conn
+		}
+
+		modifiedContent, err := os.ReadFile(tempFile)
+		if err != nil {
+			logEvent("CLIENT", "FILE_ERROR", fmt.Sprintf("Falla al leer el archivo modificado: %v", err))
+			os.Remove(tempFile)
+			return conn
+		}
+
+		// 5. SINCRONIZACIÓN
+		fileUpdate := FileUpdate{
+			FileName:         fileName,
+			Content:          modifiedContent,
+			ModificationDate: time.Now(),
+			Version:          baseVersion, // Usar la versión original para la resolución de conflictos
+			Clock:            baseClock,
+			LockToken:        lockToken,
+		}
+		payloadBytes, _ := json.Marshal(fileUpdate)
+		updateMsg := NetworkMessage{Type: "FILE_WRITE_UPDATE", Payload: payloadBytes}
+		updateResponse, err := sendToOwner(conn, fileName, updateMsg)
+
+		if err == nil && updateResponse.Type == "UPDATE_CONFLICT" {
+			var mergeConflict MergeConflict
+			if err := json.Unmarshal(updateResponse.Payload, &mergeConflict); err != nil {
+				fmt.Println("❌ Respuesta de conflicto inválida:", err)
+				break
+			}
+			if err := os.WriteFile(tempFile, mergeConflict.Content, 0644); err != nil {
+				logEvent("CLIENT", "FILE_ERROR", fmt.Sprintf("Falla al guardar los conflictos de fusión: %v", err))
+				break
+			}
+			logEvent("CLIENT", "MERGE_CONFLICT", fmt.Sprintf("Conflictos al fusionar '%s' con la versión %d.", fileName, mergeConflict.Version))
+			fmt.Println("⚠️  Otra edición modificó las mismas líneas. Resuelve los marcadores <<<<<<< ======= >>>>>>> y guarda.")
+			baseVersion, baseClock = mergeConflict.Version, mergeConflict.Clock
+			continue
+		}
+
+		if err != nil {
+			logEvent("CLIENT", "NETWORK_ERROR", fmt.Sprintf("Falla al sincronizar: %v", err))
+		} else if updateResponse.Type == "UPDATE_ACK" || updateResponse.Type == "UPDATE_MERGED" {
+			fmt.Println("✅ Servidor:", string(updateResponse.Payload))
+			// Opcional: Solicitar info actualizada para reflejar la Versión+1 en la caché
+			infoUpdateMsg := NetworkMessage{Type: "GET_FILE_INFO", Payload: fileNameBytes}
+			infoUpdateResponse, _ := sendMessage(conn, infoUpdateMsg)
+			processResponse(infoUpdateResponse)
+		} else if updateResponse.Type == "UPDATE_CONFLICT_COPY" {
+			logEvent("CLIENT", "CONFLICT_COPY", string(updateResponse.Payload))
+			fmt.Println("⚠️  Servidor:", string(updateResponse.Payload))
+		} else {
+			fmt.Println("❌ Servidor:", string(updateResponse.Payload))
+		}
+		break
 	}
-	
-	modifiedContent, err := os.ReadFile(tempFile)
+
+	// 6. CLEANUP
+	os.Remove(tempFile)
+	return conn
+}
+
+// lockLeaseSeconds es la duración del candado de edición; se renueva a un
+// tercio de ese tiempo mientras el editor esté abierto.
+const lockLeaseSeconds = 30
+
+// acquireLock pide al dueño el candado exclusivo de edición de fileName.
+func acquireLock(conn *dtls.Conn, fileName string) (string, error) {
+	holder, _ := os.Hostname()
+	payloadBytes, _ := json.Marshal(LockRequest{FileName: fileName, Holder: fmt.Sprintf("%s (pid %d)", holder, os.Getpid()), LeaseSeconds: lockLeaseSeconds})
+	response, err := sendMessage(conn, NetworkMessage{Type: "ACQUIRE_LOCK", Payload: payloadBytes})
 	if err != nil {
-		logEvent("CLIENT", "FILE_ERROR", fmt.Sprintf("Falla al leer el archivo modificado: %v", err))
-		os.Remove(tempFile)
-		return
+		return "", fmt.Errorf("falla al solicitar el candado de edición: %v", err)
 	}
-	
-	// 4. SINCRONIZACIÓN
-	fileUpdate := FileUpdate{
-		FileName:         fileName,
-		Content:          modifiedContent,
-		ModificationDate: time.Now(),
-		Version:          entry.Version, // Usar la versión original para la resolución de conflictos
+	if response.Type != "LOCK_GRANTED" {
+		return "", fmt.Errorf("no se pudo bloquear '%s' para edición: %s", fileName, string(response.Payload))
 	}
-	payloadBytes, _ := json.Marshal(fileUpdate)
-	updateMsg := NetworkMessage{Type: "FILE_WRITE_UPDATE", Payload: payloadBytes}
-	updateResponse, err := sendMessage(conn, updateMsg)
-	
-	// 5. CLEANUP
-	os.Remove(tempFile)
-	
-	if err != nil {
-		logEvent("CLIENT", "NETWORK_ERROR", fmt.Sprintf("Falla al sincronizar: %v", err))
-	} else if updateResponse.Type == "UPDATE_ACK" {
-		fmt.Println("✅ Servidor:", string(updateResponse.Payload))
-		// Opcional: Solicitar info actualizada para reflejar la Versión+1 en la caché
-		infoUpdateMsg := NetworkMessage{Type: "GET_FILE_INFO", Payload: fileNameBytes}
-		infoUpdateResponse, _ := sendMessage(conn, infoUpdateMsg)
-		processResponse(infoUpdateResponse)
-	} else {
-		fmt.Println("❌ Servidor:", string(updateResponse.Payload))
+	var grant LockGrant
+	json.Unmarshal(response.Payload, &grant)
+	logEvent("CLIENT", "LOCK_ACQUIRED", fmt.Sprintf("Candado de '%s' obtenido hasta %s.", fileName, grant.Expires.Format(time.RFC3339)))
+	return grant.Token, nil
+}
+
+// renewLockWhileEditing renueva el candado en segundo plano hasta que se llame
+// a la función devuelta, que espera a que termine la última renovación para
+// que la conexión quede libre.
+func renewLockWhileEditing(conn *dtls.Conn, fileName, token string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockLeaseSeconds * time.Second / 3)
+		defer ticker.Stop()
+		payloadBytes, _ := json.Marshal(LockRequest{FileName: fileName, Token: token, LeaseSeconds: lockLeaseSeconds})
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				response, err := sendMessage(conn, NetworkMessage{Type: "RENEW_LOCK", Payload: payloadBytes})
+				if err != nil || response.Type != "LOCK_GRANTED" {
+					logEvent("CLIENT", "LOCK_LOST", fmt.Sprintf("No se pudo renovar el candado de '%s'.", fileName))
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// releaseLock libera el candado de edición; si falla, el arrendamiento expira solo.
+func releaseLock(conn *dtls.Conn, fileName, token string) {
+	payloadBytes, _ := json.Marshal(LockRequest{FileName: fileName, Token: token})
+	if _, err := sendMessage(conn, NetworkMessage{Type: "RELEASE_LOCK", Payload: payloadBytes}); err != nil {
+		logEvent("CLIENT", "LOCK_ERROR", fmt.Sprintf("Falla al liberar el candado de '%s': %v", fileName, err))
 	}
 }
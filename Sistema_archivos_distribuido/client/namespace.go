@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pion/dtls/v2"
+)
+
+// listDirPageSize es cuántas entradas se piden por página de LIST_DIR.
+const listDirPageSize = 20
+
+// cwd es la carpeta actual del cliente en el DFS ("" es la raíz). Los nombres
+// relativos de los comandos se resuelven contra ella.
+var cwd string
+
+// resolvePath traduce un nombre escrito por el usuario a una ruta del DFS. Un
+// "/" inicial lo hace absoluto; "." y ".." se resuelven sin salir de la raíz.
+func resolvePath(arg string) string {
+	var resolved string
+	if strings.HasPrefix(arg, "/") {
+		resolved = path.Clean(arg)
+	} else {
+		resolved = path.Join("/", cwd, arg)
+	}
+	return strings.TrimPrefix(resolved, "/")
+}
+
+// displayPath muestra una ruta del DFS como absoluta.
+func displayPath(dir string) string {
+	return "/" + dir
+}
+
+// localName aplana una ruta del DFS para usarla como nombre de un archivo
+// local en la carpeta del cliente, donde no existen sus carpetas.
+func localName(fileName string) string {
+	return strings.ReplaceAll(fileName, "/", "_")
+}
+
+// listDirectory muestra los hijos de una carpeta página a página, preguntando
+// antes de pedir la siguiente.
+func listDirectory(conn *dtls.Conn, dir string) {
+	req := ListDirRequest{Path: dir, Limit: listDirPageSize}
+	fmt.Printf("\n--- Contenido de %s ---\n", displayPath(dir))
+	for {
+		payloadBytes, _ := json.Marshal(req)
+		responseMsg, err := sendMessage(conn, NetworkMessage{Type: "LIST_DIR", Payload: payloadBytes})
+		if err != nil {
+			logEvent("CLIENT", "NETWORK_ERROR", fmt.Sprintf("Falla al listar '%s': %v", displayPath(dir), err))
+			printMenu()
+			return
+		}
+		if responseMsg.Type != "DIR_LISTING" {
+			processResponse(responseMsg)
+			return
+		}
+		var page ListDirPage
+		json.Unmarshal(responseMsg.Payload, &page)
+		for _, entry := range page.Entries {
+			name := path.Base(entry.FileName)
+			if entry.IsDir {
+				fmt.Printf("  %s/  (dueño: %s)\n", name, entry.OwnerIP)
+			} else {
+				fmt.Printf("  %s  %d bytes, versión %d (dueño: %s)\n", name, entry.Size, entry.Version, entry.OwnerIP)
+			}
+		}
+		if page.NextCursor == "" || !confirm("¿Mostrar más?") {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	fmt.Println("----------------------------------------")
+	printMenu()
+}
+
+// changeDirectory cambia la carpeta actual tras comprobar que existe.
+func changeDirectory(conn *dtls.Conn, dir string) {
+	if dir != "" {
+		dirBytes, _ := json.Marshal(dir)
+		responseMsg, err := sendMessage(conn, NetworkMessage{Type: "GET_FILE_INFO", Payload: dirBytes})
+		if err != nil {
+			logEvent("CLIENT", "NETWORK_ERROR", fmt.Sprintf("Falla al consultar '%s': %v", displayPath(dir), err))
+			printMenu()
+			return
+		}
+		var entry DirectoryEntry
+		json.Unmarshal(responseMsg.Payload, &entry)
+		if responseMsg.Type != "RESPONSE" || !entry.IsDir {
+			fmt.Printf("❌ '%s' no es una carpeta.\n", displayPath(dir))
+			printMenu()
+			return
+		}
+	}
+	cwd = dir
+	printMenu()
+}
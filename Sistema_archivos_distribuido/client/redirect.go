@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// maxRedirectHops limita cuántos REDIRECT_OWNER se siguen para una petición,
+// para no quedar atrapados si dos servidores se redirigen entre sí.
+const maxRedirectHops = 3
+
+// ownerDialTimeout limita el handshake con un dueño o réplica concretos.
+const ownerDialTimeout = 5 * time.Second
+
+// connectToPeerFromAddr abre una sesión DTLS con un servidor concreto, por
+// ejemplo el dueño de un archivo indicado en un REDIRECT_OWNER.
+func connectToPeerFromAddr(addr string) (*dtls.Conn, error) {
+	dtlsConfig, err := getDTLSConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	// Un dueño caído no debe bloquear al cliente durante todo el handshake.
+	dtlsConfig.ConnectContextMaker = func() (context.Context, func()) {
+		return context.WithTimeout(context.Background(), ownerDialTimeout)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("falla al resolver dirección %s: %v", addr, err)
+	}
+	conn, err := dtls.Dial("udp", udpAddr, dtlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("falla al conectar con %s: %v", addr, err)
+	}
+	logEvent("CLIENT", "CONNECTION_SUCCESS", fmt.Sprintf("Conectado con éxito a: %s", addr))
+	return conn, nil
+}
+
+// rememberOwner guarda en localDirectory dónde está el dueño de fileName.
+func rememberOwner(fileName, owner string) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, found := localDirectory[fileName]
+	if !found {
+		entry = DirectoryEntry{FileName: fileName}
+	}
+	entry.OwnerIP = owner
+	localDirectory[fileName] = entry
+}
+
+// cachedEntry devuelve la entrada de fileName guardada en localDirectory.
+func cachedEntry(fileName string) (DirectoryEntry, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, found := localDirectory[fileName]
+	return entry, found
+}
+
+// sendToOwner envía una petición sobre fileName y, si el servidor contesta
+// REDIRECT_OWNER, la repite directamente al dueño indicado (hasta
+// maxRedirectHops saltos). Las sesiones con el dueño se cierran al terminar.
+func sendToOwner(conn *dtls.Conn, fileName string, msg NetworkMessage) (NetworkMessage, error) {
+	responseMsg, err := sendMessage(conn, msg)
+	for hops := 0; err == nil && responseMsg.Type == "REDIRECT_OWNER"; hops++ {
+		owner := string(responseMsg.Payload)
+		if hops >= maxRedirectHops {
+			return responseMsg, fmt.Errorf("demasiadas redirecciones para '%s' (última: %s)", fileName, owner)
+		}
+		rememberOwner(fileName, owner)
+		logEvent("CLIENT", "REDIRECT", fmt.Sprintf("'%s' pertenece a %s. Reenviando %s al dueño.", fileName, owner, msg.Type))
+		ownerConn, cerr := connectToPeerFromAddr(owner)
+		if cerr != nil {
+			return responseMsg, fmt.Errorf("el dueño de '%s' (%s) no responde: %v", fileName, owner, cerr)
+		}
+		responseMsg, err = sendMessage(ownerConn, msg)
+		ownerConn.Close()
+	}
+	return responseMsg, err
+}
+
+// executeOnOwner es executeAndProcess para peticiones que sólo atiende el dueño
+// del archivo: sigue las redirecciones antes de mostrar la respuesta.
+func executeOnOwner(conn *dtls.Conn, fileName string, msg NetworkMessage) {
+	responseMsg, err := sendToOwner(conn, fileName, msg)
+	if err != nil {
+		logEvent("CLIENT", "NETWORK_ERROR", fmt.Sprintf("Falla al ejecutar comando: %v", err))
+		printMenu()
+		return
+	}
+	processResponse(responseMsg)
+}
+
+// refreshEntry pide los atributos de fileName y los guarda en localDirectory.
+func refreshEntry(conn *dtls.Conn, fileName string) {
+	fileNameBytes, _ := json.Marshal(fileName)
+	responseMsg, err := sendMessage(conn, NetworkMessage{Type: "GET_FILE_INFO", Payload: fileNameBytes})
+	if err != nil || responseMsg.Type != "RESPONSE" {
+		return
+	}
+	var entry DirectoryEntry
+	json.Unmarshal(responseMsg.Payload, &entry)
+	mu.Lock()
+	localDirectory[fileName] = entry
+	mu.Unlock()
+}
+
+// confirm hace una pregunta de sí o no en la terminal.
+func confirm(question string) bool {
+	fmt.Printf("%s (s/n): ", question)
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "s" || answer == "si" || answer == "sí"
+}
+
+// connectToReplica ofrece leer fileName desde una de sus réplicas cuando el
+// dueño no responde. Devuelve la sesión con la réplica aceptada y su dirección.
+func connectToReplica(conn *dtls.Conn, fileName, unreachableOwner string) (*dtls.Conn, string, error) {
+	entry, found := cachedEntry(fileName)
+	if !found || len(entry.Replicas) == 0 {
+		// Sin réplicas conocidas: se piden los atributos al servidor actual.
+		refreshEntry(conn, fileName)
+		entry, found = cachedEntry(fileName)
+	}
+	if !found || len(entry.Replicas) == 0 {
+		return nil, "", fmt.Errorf("el dueño %s no responde y '%s' no tiene réplicas conocidas", unreachableOwner, fileName)
+	}
+	if !confirm(fmt.Sprintf("El dueño %s no responde. ¿Leer '%s' desde una réplica?", unreachableOwner, fileName)) {
+		return nil, "", fmt.Errorf("el dueño %s no responde", unreachableOwner)
+	}
+	for _, replica := range entry.Replicas {
+		if replica == unreachableOwner {
+			continue
+		}
+		replicaConn, err := connectToPeerFromAddr(replica)
+		if err != nil {
+			logEvent("CLIENT", "CONNECTION_FAILURE", err.Error())
+			continue
+		}
+		logEvent("CLIENT", "REPLICA_FALLBACK", fmt.Sprintf("Leyendo '%s' desde la réplica %s.", fileName, replica))
+		return replicaConn, replica, nil
+	}
+	return nil, "", fmt.Errorf("ninguna réplica de '%s' responde", fileName)
+}
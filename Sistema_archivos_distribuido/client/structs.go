@@ -4,14 +4,37 @@ import "time"
 
 // DirectoryEntry define la estructura de una entrada en el directorio de archivos.
 type DirectoryEntry struct {
-	FileName         string    `json:"file_name"`
-	Extension        string    `json:"extension"`
-	Size             int64     `json:"size"`
-	CreationDate     time.Time `json:"creation_date"`
-	ModificationDate time.Time `json:"modification_date"`
-	Version          int64     `json:"version"` // Nuevo campo
-	TTL              int       `json:"ttl"`
-	OwnerIP          string    `json:"owner_ip"`
+	FileName         string           `json:"file_name"`
+	Extension        string           `json:"extension"`
+	Size             int64            `json:"size"`
+	CreationDate     time.Time        `json:"creation_date"`
+	ModificationDate time.Time        `json:"modification_date"`
+	Version          int64            `json:"version"` // Nuevo campo
+	Clock            map[string]int64 `json:"clock,omitempty"`
+	TTL              int              `json:"ttl"`
+	OwnerIP          string           `json:"owner_ip"`
+	Deleted          bool             `json:"deleted,omitempty"`
+	Unavailable      bool             `json:"unavailable,omitempty"`
+	Replicas         []string         `json:"replicas,omitempty"`
+	IsDir            bool             `json:"is_dir,omitempty"`
+	ACL              *FileACL         `json:"acl,omitempty"`
+}
+
+// FileACL son los permisos de una entrada: el dueño, su grupo con el permiso
+// del grupo, el permiso de los demás y concesiones por usuario (CN).
+type FileACL struct {
+	Owner     string            `json:"owner"`
+	Group     string            `json:"group,omitempty"`
+	GroupPerm string            `json:"group_perm,omitempty"`
+	OtherPerm string            `json:"other_perm,omitempty"`
+	Grants    map[string]string `json:"grants,omitempty"`
+}
+
+// ACLChange es el payload de SET_ACL.
+type ACLChange struct {
+	FileName string `json:"file_name"`
+	Target   string `json:"target"`
+	Perm     string `json:"perm"`
 }
 
 // NetworkMessage se mantiene igual
@@ -20,12 +43,93 @@ type NetworkMessage struct {
 	Payload       []byte `json:"payload"`
 	Authoritative bool   `json:"authoritative"`
 	SenderIP      string `json:"sender_ip"`
+	Reason        string `json:"reason,omitempty"` // Código de motivo de un NACK.
 }
 
 // FileUpdate encapsula los datos necesarios para una actualización de archivo.
 type FileUpdate struct {
-	FileName         string
-	Content          []byte
-	Version          int64
-	ModificationDate time.Time
+	FileName         string           `json:"file_name"`
+	Content          []byte           `json:"content"`
+	Version          int64            `json:"version"`
+	ModificationDate time.Time        `json:"modification_date"`
+	Clock            map[string]int64 `json:"clock,omitempty"` // Reloj de la versión descargada.
+	LockToken        string           `json:"lock_token,omitempty"`
+}
+
+// LockRequest es el payload de ACQUIRE_LOCK, RENEW_LOCK y RELEASE_LOCK.
+type LockRequest struct {
+	FileName     string `json:"file_name"`
+	Holder       string `json:"holder,omitempty"`
+	Token        string `json:"token,omitempty"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"`
+}
+
+// LockGrant llega con LOCK_GRANTED.
+type LockGrant struct {
+	FileName string    `json:"file_name"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+}
+
+// MergeConflict llega con UPDATE_CONFLICT: el archivo fusionado con marcadores
+// y el reloj de la versión contra la que se debe reenviar la resolución.
+type MergeConflict struct {
+	FileName string           `json:"file_name"`
+	Content  []byte           `json:"content"`
+	Version  int64            `json:"version"`
+	Clock    map[string]int64 `json:"clock"`
+}
+
+// VersionRequest identifica una versión concreta de un archivo (GET_VERSION y ROLLBACK).
+type VersionRequest struct {
+	FileName string `json:"file_name"`
+	Version  int64  `json:"version"`
+}
+
+// VersionRecord es una versión guardada por el dueño, según RESPONSE_VERSIONS.
+type VersionRecord struct {
+	Version          int64            `json:"version"`
+	Clock            map[string]int64 `json:"clock"`
+	Hash             string           `json:"hash"`
+	Size             int64            `json:"size"`
+	ModificationDate time.Time        `json:"modification_date"`
+}
+
+// FileChunkRequest pide un rango de bytes de un archivo al dueño.
+type FileChunkRequest struct {
+	FileName string `json:"file_name"`
+	Offset   int64  `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// FileChunk es un fragmento verificable de un archivo remoto.
+type FileChunk struct {
+	FileName    string `json:"file_name"`
+	Offset      int64  `json:"offset"`
+	Data        []byte `json:"data"`
+	ChunkSHA256 string `json:"chunk_sha256"`
+	FileSize    int64  `json:"file_size"`
+	FileSHA256  string `json:"file_sha256"`
+	Version     int64  `json:"version"`
+	EOF         bool   `json:"eof"`
+}
+
+// ListDirRequest es el payload de LIST_DIR.
+type ListDirRequest struct {
+	Path   string `json:"path"`
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// ListDirPage es una página de DIR_LISTING.
+type ListDirPage struct {
+	Path       string           `json:"path"`
+	Entries    []DirectoryEntry `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// MoveRequest es el payload de MOVE.
+type MoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
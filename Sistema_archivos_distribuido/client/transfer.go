@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+const (
+	chunkLength      = 32 * 1024
+	maxResumeRetries = 5
+)
+
+// downloadFile descarga un archivo por fragmentos verificados con SHA-256 en un
+// archivo temporal. Si la sesión DTLS se cae, reconecta y reanuda desde el último
+// desplazamiento verificado. Si el servidor redirige al dueño, los fragmentos se
+// piden directamente a él y, si no responde, se ofrece leer de una réplica.
+// Devuelve la conexión vigente con el servidor, que puede ser nueva.
+func downloadFile(conn *dtls.Conn, fileName string) ([]byte, *dtls.Conn, error) {
+	partPath := "download_" + localName(fileName) + ".part"
+	metaPath := partPath + ".sha256"
+
+	// El archivo .sha256 guarda el hash esperado del archivo completo; sin él
+	// no se puede saber si lo descargado pertenece a la misma versión.
+	expectedSum := ""
+	if meta, err := os.ReadFile(metaPath); err == nil {
+		expectedSum = strings.TrimSpace(string(meta))
+	}
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && expectedSum != "" {
+		offset = info.Size()
+		logEvent("CLIENT", "DOWNLOAD_RESUME", fmt.Sprintf("Reanudando descarga de '%s' desde el byte %d.", fileName, offset))
+	}
+
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, conn, fmt.Errorf("falla al crear archivo temporal: %v", err)
+	}
+	defer part.Close()
+	if err := part.Truncate(offset); err != nil {
+		return nil, conn, fmt.Errorf("falla al preparar archivo temporal: %v", err)
+	}
+
+	// source es la sesión a la que se piden los fragmentos: el servidor (conn) o,
+	// tras una redirección, el dueño o una réplica en sourceAddr.
+	source, sourceAddr := conn, ""
+	defer func() {
+		if source != conn {
+			source.Close()
+		}
+	}()
+	if entry, found := cachedEntry(fileName); found && entry.OwnerIP != "" && entry.OwnerIP != conn.RemoteAddr().String() {
+		// Dueño ya conocido: se evita la redirección.
+		if ownerConn, err := connectToPeerFromAddr(entry.OwnerIP); err == nil {
+			source, sourceAddr = ownerConn, entry.OwnerIP
+		}
+	}
+
+	retries, hops := 0, 0
+	for {
+		req := FileChunkRequest{FileName: fileName, Offset: offset, Length: chunkLength}
+		payloadBytes, _ := json.Marshal(req)
+		responseMsg, err := sendMessage(source, NetworkMessage{Type: "REQUEST_FILE_CHUNK", Payload: payloadBytes})
+		if err != nil {
+			retries++
+			if retries > maxResumeRetries {
+				return nil, conn, fmt.Errorf("descarga interrumpida en el byte %d tras %d reintentos: %v", offset, maxResumeRetries, err)
+			}
+			logEvent("CLIENT", "DOWNLOAD_RETRY", fmt.Sprintf("Sesión perdida descargando '%s' (%v). Reconectando, intento %d.", fileName, err, retries))
+			source.Close()
+			time.Sleep(time.Duration(retries) * time.Second)
+			var newConn *dtls.Conn
+			var cerr error
+			if source == conn {
+				newConn, cerr = connectToPeer()
+			} else {
+				newConn, cerr = connectToPeerFromAddr(sourceAddr)
+			}
+			if cerr != nil {
+				logEvent("CLIENT", "CONNECTION_FAILURE", fmt.Sprintf("Falla al reconectar: %v", cerr))
+				continue
+			}
+			if source == conn {
+				conn = newConn
+			}
+			source = newConn
+			continue
+		}
+		if responseMsg.Type == "REDIRECT_OWNER" {
+			hops++
+			owner := string(responseMsg.Payload)
+			if hops > maxRedirectHops {
+				return nil, conn, fmt.Errorf("demasiadas redirecciones para '%s' (última: %s)", fileName, owner)
+			}
+			rememberOwner(fileName, owner)
+			logEvent("CLIENT", "REDIRECT", fmt.Sprintf("'%s' pertenece a %s. Descargando directamente del dueño.", fileName, owner))
+			nextAddr := owner
+			next, err := connectToPeerFromAddr(owner)
+			if err != nil {
+				logEvent("CLIENT", "CONNECTION_FAILURE", err.Error())
+				next, nextAddr, err = connectToReplica(conn, fileName, owner)
+				if err != nil {
+					return nil, conn, err
+				}
+			}
+			if source != conn {
+				source.Close()
+			}
+			source, sourceAddr = next, nextAddr
+			continue
+		}
+		if responseMsg.Type != "FILE_CHUNK" {
+			return nil, conn, fmt.Errorf("%s: %s", responseMsg.Type, string(responseMsg.Payload))
+		}
+
+		var chunk FileChunk
+		if err := json.Unmarshal(responseMsg.Payload, &chunk); err != nil {
+			return nil, conn, fmt.Errorf("fragmento malformado: %v", err)
+		}
+
+		if chunk.FileSHA256 != expectedSum {
+			if offset > 0 {
+				logEvent("CLIENT", "DOWNLOAD_RESTART", fmt.Sprintf("'%s' cambió en el servidor. Reiniciando la descarga.", fileName))
+				offset = 0
+				part.Truncate(0)
+			}
+			expectedSum = chunk.FileSHA256
+			os.WriteFile(metaPath, []byte(expectedSum), 0644)
+			if chunk.Offset != 0 {
+				continue
+			}
+		}
+
+		sum := sha256.Sum256(chunk.Data)
+		if chunk.Offset != offset || hex.EncodeToString(sum[:]) != chunk.ChunkSHA256 {
+			retries++
+			if retries > maxResumeRetries {
+				return nil, conn, fmt.Errorf("fragmento en el byte %d no pasó la verificación", offset)
+			}
+			logEvent("CLIENT", "CHUNK_CORRUPT", fmt.Sprintf("Fragmento de '%s' en el byte %d no coincide con su checksum. Solicitando de nuevo.", fileName, offset))
+			continue
+		}
+
+		if _, err := part.WriteAt(chunk.Data, offset); err != nil {
+			return nil, conn, fmt.Errorf("falla al escribir archivo temporal: %v", err)
+		}
+		offset += int64(len(chunk.Data))
+		retries = 0
+		if chunk.EOF {
+			break
+		}
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return nil, conn, err
+	}
+	content, err := io.ReadAll(part)
+	if err != nil {
+		return nil, conn, fmt.Errorf("falla al leer archivo temporal: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	part.Close()
+	os.Remove(metaPath)
+	os.Remove(partPath)
+	if hex.EncodeToString(sum[:]) != expectedSum {
+		return nil, conn, fmt.Errorf("el checksum del archivo completo no coincide; se descartó la descarga")
+	}
+	logEvent("CLIENT", "DOWNLOAD_COMPLETE", fmt.Sprintf("Archivo '%s' descargado y verificado (%d bytes).", fileName, len(content)))
+	return content, conn, nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	kindServer = "server"
+	kindClient = "client"
+
+	organization = "My Distributed Directory"
+	rsaKeyBits   = 2048
+	// clockSkew adelanta NotBefore para que un nodo con el reloj un poco
+	// atrasado no rechace un certificado recién emitido.
+	clockSkew = 5 * time.Minute
+)
+
+// authority es una CA cargada desde su carpeta.
+type authority struct {
+	Dir  string
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// certRequest describe la identidad de un certificado a emitir.
+type certRequest struct {
+	Kind        string
+	CommonName  string
+	Groups      []string // OU: los grupos del cliente en las ACL.
+	DNSNames    []string
+	IPAddresses []net.IP
+	Validity    time.Duration
+}
+
+func (req *certRequest) parseIPs(values []string) error {
+	for _, value := range values {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("IP inválida: %s", value)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	return nil
+}
+
+// validate completa el CN de los servidores y comprueba que la petición tenga
+// lo necesario para su tipo.
+func (req *certRequest) validate() error {
+	if req.Validity <= 0 {
+		return fmt.Errorf("la validez debe ser positiva")
+	}
+	switch req.Kind {
+	case kindServer:
+		if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+			return fmt.Errorf("un certificado de servidor necesita al menos un -ip o un -dns")
+		}
+		if req.CommonName == "" {
+			if len(req.DNSNames) > 0 {
+				req.CommonName = req.DNSNames[0]
+			} else {
+				req.CommonName = req.IPAddresses[0].String()
+			}
+		}
+	case kindClient:
+		if req.CommonName == "" {
+			return fmt.Errorf("un certificado de cliente necesita -name: es su identidad en las ACL")
+		}
+	}
+	return nil
+}
+
+// extKeyUsages devuelve los usos extendidos de cada tipo. Los servidores
+// también llevan el de cliente porque se presentan con su certificado al
+// conectarse a sus peers.
+func extKeyUsages(kind string) []x509.ExtKeyUsage {
+	if kind == kindServer {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+}
+
+// certKind deduce el tipo de un certificado por sus usos extendidos.
+func certKind(cert *x509.Certificate) string {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			return kindServer
+		}
+	}
+	return kindClient
+}
+
+// describe resume la identidad de un certificado para los mensajes.
+func describe(cert *x509.Certificate) string {
+	parts := []string{"CN=" + cert.Subject.CommonName}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		parts = append(parts, "grupos="+strings.Join(cert.Subject.OrganizationalUnit, ","))
+	}
+	if sans := subjectAltNames(cert); len(sans) > 0 {
+		parts = append(parts, "SAN="+strings.Join(sans, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// sameSet compara dos listas sin importar el orden.
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matches indica si cert ya tiene la identidad pedida.
+func (req certRequest) matches(cert *x509.Certificate) bool {
+	var ips []string
+	for _, ip := range req.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	var certIPs []string
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	return certKind(cert) == req.Kind &&
+		cert.Subject.CommonName == req.CommonName &&
+		sameSet(cert.Subject.OrganizationalUnit, req.Groups) &&
+		sameSet(cert.DNSNames, req.DNSNames) &&
+		sameSet(certIPs, ips)
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("fallo al generar el número de serie: %v", err)
+	}
+	return serial, nil
+}
+
+// writeFileAtomic escribe un archivo completo o ninguno.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func writeKey(path string, key *rsa.PrivateKey) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("fallo al escribir la clave '%s': %v", path, err)
+	}
+	return nil
+}
+
+func writeCert(path string, der []byte) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("fallo al escribir el certificado '%s': %v", path, err)
+	}
+	return nil
+}
+
+// readKey lee una clave privada PEM en PKCS#1 (la que genera este comando) o PKCS#8.
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al leer la clave '%s': %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("'%s' no está en formato PEM", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al analizar la clave '%s': %v", path, err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("la clave '%s' no sirve para firmar", path)
+	}
+	return signer, nil
+}
+
+// readCert lee un certificado PEM.
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al leer '%s': %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("'%s' no está en formato PEM", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("fallo al analizar '%s': %v", path, err)
+	}
+	return cert, nil
+}
+
+// keyMatches indica si key es la clave privada del certificado.
+func keyMatches(cert *x509.Certificate, key crypto.Signer) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+func (ca *authority) certPath() string { return filepath.Join(ca.Dir, "ca.crt") }
+func (ca *authority) keyPath() string  { return filepath.Join(ca.Dir, "ca.key") }
+
+// loadCA carga la CA de dir y comprueba que la clave corresponda al certificado.
+func loadCA(dir string) (*authority, error) {
+	ca := &authority{Dir: dir}
+	cert, err := readCert(ca.certPath())
+	if err != nil {
+		return nil, fmt.Errorf("%v (¿falta ejecutar 'dfs-ca init'?)", err)
+	}
+	key, err := readKey(ca.keyPath())
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("'%s' no es un certificado de CA", ca.certPath())
+	}
+	if !keyMatches(cert, key) {
+		return nil, fmt.Errorf("'%s' no es la clave de '%s'", ca.keyPath(), ca.certPath())
+	}
+	ca.Cert, ca.Key = cert, key
+	return ca, nil
+}
+
+// initCA crea la CA de dir. Si ya existe la carga sin tocarla, de modo que
+// ejecutarlo de nuevo no invalida los certificados emitidos.
+func initCA(dir, commonName string, validity time.Duration) (*authority, bool, error) {
+	ca := &authority{Dir: dir}
+	_, certErr := os.Stat(ca.certPath())
+	_, keyErr := os.Stat(ca.keyPath())
+	if certErr == nil && keyErr == nil {
+		existing, err := loadCA(dir)
+		return existing, false, err
+	}
+	if certErr == nil || keyErr == nil {
+		return nil, false, fmt.Errorf("en '%s' sólo existe uno de ca.crt y ca.key; no se sobrescribe", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, fmt.Errorf("fallo al crear '%s': %v", dir, err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, false, fmt.Errorf("fallo al generar la clave de la CA: %v", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, false, err
+	}
+	// Sin ExtKeyUsage: la CA puede firmar certificados de servidor y de cliente.
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{organization}, CommonName: commonName},
+		NotBefore:             time.Now().Add(-clockSkew),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("fallo al crear el certificado de la CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	// La clave primero: un ca.crt sin su clave no serviría para nada.
+	if err := writeKey(ca.keyPath(), key); err != nil {
+		return nil, false, err
+	}
+	if err := writeCert(ca.certPath(), der); err != nil {
+		return nil, false, err
+	}
+	ca.Cert, ca.Key = cert, key
+	return ca, true, nil
+}
+
+// legacyWarning avisa si la CA limita los usos extendidos, como la que creaba
+// setup_ca.go (sólo ServerAuth).
+func (ca *authority) legacyWarning() string {
+	if len(ca.Cert.ExtKeyUsage) == 0 {
+		return ""
+	}
+	for _, usage := range ca.Cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageAny || usage == x509.ExtKeyUsageClientAuth {
+			return ""
+		}
+	}
+	return "la CA sólo admite el uso de servidor (fue creada por setup_ca.go). Los servidores del DFS aceptan igualmente sus certificados de cliente, pero otras herramientas TLS los rechazarán; para evitarlo cree una CA nueva en otra carpeta y reemita los certificados."
+}
+
+// sign emite un certificado para pub con la identidad de template.
+func (ca *authority) sign(template *x509.Certificate, pub crypto.PublicKey) (*x509.Certificate, []byte, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, pub, ca.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fallo al firmar el certificado: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, der, nil
+}
+
+// issuedByThisCA indica si cert es un certificado vigente emitido por esta CA.
+func (ca *authority) issuedByThisCA(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.Cert) == nil && time.Now().Before(cert.NotAfter)
+}
+
+// issueTo emite un certificado con una clave nueva en <out>.crt y <out>.key.
+// Si ya hay ahí un certificado vigente de esta CA con la misma identidad, no
+// revocado, lo devuelve sin emitir otro; si la identidad es otra, sólo lo
+// reemplaza con force.
+func (ca *authority) issueTo(req certRequest, out string, force bool) (*x509.Certificate, bool, error) {
+	certPath, keyPath := out+".crt", out+".key"
+	if existing, err := readCert(certPath); err == nil {
+		revoked, err := ca.revokedSerials()
+		if err != nil {
+			return nil, false, err
+		}
+		current := ca.issuedByThisCA(existing) && !revoked[existing.SerialNumber.String()]
+		if current && req.matches(existing) {
+			return existing, false, nil
+		}
+		if !force {
+			return nil, false, fmt.Errorf("'%s' ya existe (%s); use -force para reemplazarlo", certPath, describe(existing))
+		}
+	} else if _, statErr := os.Stat(certPath); statErr == nil && !force {
+		return nil, false, fmt.Errorf("'%s' existe pero no se puede leer (%v); use -force para reemplazarlo", certPath, err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, false, fmt.Errorf("fallo al generar la clave: %v", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, false, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{organization},
+			OrganizationalUnit: req.Groups,
+			CommonName:         req.CommonName,
+		},
+		DNSNames:              req.DNSNames,
+		IPAddresses:           req.IPAddresses,
+		NotBefore:             time.Now().Add(-clockSkew),
+		NotAfter:              time.Now().Add(req.Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           extKeyUsages(req.Kind),
+		BasicConstraintsValid: true,
+	}
+	cert, der, err := ca.sign(template, &key.PublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := writeKey(keyPath, key); err != nil {
+		return nil, false, err
+	}
+	if err := writeCert(certPath, der); err != nil {
+		return nil, false, err
+	}
+	return cert, true, ca.record(cert, certPath)
+}
+
+// renew reemite el certificado de certPath con la misma identidad y la misma
+// clave (<prefijo>.key), un número de serie nuevo y una validez nueva.
+// Devuelve el certificado anterior y el renovado.
+func (ca *authority) renew(certPath string, validity time.Duration) (*x509.Certificate, *x509.Certificate, error) {
+	old, err := readCert(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := old.CheckSignatureFrom(ca.Cert); err != nil {
+		return nil, nil, fmt.Errorf("'%s' no fue emitido por esta CA: %v", certPath, err)
+	}
+	keyPath := strings.TrimSuffix(certPath, ".crt") + ".key"
+	key, err := readKey(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !keyMatches(old, key) {
+		return nil, nil, fmt.Errorf("'%s' no es la clave de '%s'", keyPath, certPath)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               old.Subject,
+		DNSNames:              old.DNSNames,
+		IPAddresses:           old.IPAddresses,
+		NotBefore:             time.Now().Add(-clockSkew),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              old.KeyUsage,
+		ExtKeyUsage:           extKeyUsages(certKind(old)),
+		BasicConstraintsValid: true,
+	}
+	renewed, der, err := ca.sign(template, key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeCert(certPath, der); err != nil {
+		return nil, nil, err
+	}
+	return old, renewed, ca.record(renewed, certPath)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func newTestCA(t *testing.T) *authority {
+	t.Helper()
+	ca, created, err := initCA(t.TempDir(), "CA de prueba", 365*day)
+	if err != nil || !created {
+		t.Fatalf("initCA = %v, creada %v", err, created)
+	}
+	return ca
+}
+
+func TestInitCAIsIdempotent(t *testing.T) {
+	ca := newTestCA(t)
+	again, created, err := initCA(ca.Dir, "otro nombre", day)
+	if err != nil || created {
+		t.Fatalf("segundo initCA = %v, creada %v", err, created)
+	}
+	if !again.Cert.Equal(ca.Cert) {
+		t.Error("el segundo initCA cambió el certificado de la CA")
+	}
+
+	half := t.TempDir()
+	keyOnly, _ := os.ReadFile(ca.keyPath())
+	os.WriteFile(filepath.Join(half, "ca.key"), keyOnly, 0600)
+	if _, _, err := initCA(half, "CA", day); err == nil {
+		t.Error("initCA sobrescribió una CA a medias")
+	}
+}
+
+func TestCertRequestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    certRequest
+		wantCN string
+		ok     bool
+	}{
+		{"servidor con DNS", certRequest{Kind: kindServer, DNSNames: []string{"nodo1"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}, Validity: day}, "nodo1", true},
+		{"servidor con IP", certRequest{Kind: kindServer, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}, Validity: day}, "10.0.0.1", true},
+		{"servidor sin SAN", certRequest{Kind: kindServer, CommonName: "nodo1", Validity: day}, "", false},
+		{"cliente", certRequest{Kind: kindClient, CommonName: "alice", Validity: day}, "alice", true},
+		{"cliente sin nombre", certRequest{Kind: kindClient, Validity: day}, "", false},
+		{"validez nula", certRequest{Kind: kindClient, CommonName: "alice"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("validate = %v; válida esperada: %v", err, tt.ok)
+			}
+			if tt.ok && tt.req.CommonName != tt.wantCN {
+				t.Errorf("CN = %q, se esperaba %q", tt.req.CommonName, tt.wantCN)
+			}
+		})
+	}
+	req := certRequest{}
+	if err := req.parseIPs([]string{"no-es-ip"}); err == nil {
+		t.Error("parseIPs aceptó una IP inválida")
+	}
+}
+
+func TestIssue(t *testing.T) {
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	tests := []struct {
+		name      string
+		req       certRequest
+		usages    []x509.ExtKeyUsage
+		dnsName   string
+		wantGroup string
+	}{
+		{
+			"servidor",
+			certRequest{Kind: kindServer, DNSNames: []string{"localhost"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, Validity: day},
+			[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			"localhost", "",
+		},
+		{
+			"cliente",
+			certRequest{Kind: kindClient, CommonName: "alice", Groups: []string{"devs"}, Validity: day},
+			[]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			"", "devs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(t.TempDir(), tt.name)
+			cert, issued, err := ca.issueTo(tt.req, out, false)
+			if err != nil || !issued {
+				t.Fatalf("issueTo = %v, emitido %v", err, issued)
+			}
+			for _, usage := range tt.usages {
+				_, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: tt.dnsName, KeyUsages: []x509.ExtKeyUsage{usage}})
+				if err != nil {
+					t.Errorf("no verifica para el uso %v: %v", usage, err)
+				}
+			}
+			if len(cert.ExtKeyUsage) != len(tt.usages) {
+				t.Errorf("usos extendidos = %v, se esperaban %v", cert.ExtKeyUsage, tt.usages)
+			}
+			if tt.wantGroup != "" && (len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != tt.wantGroup) {
+				t.Errorf("OU = %v, se esperaba %s", cert.Subject.OrganizationalUnit, tt.wantGroup)
+			}
+			if _, err := readKey(out + ".key"); err != nil {
+				t.Errorf("clave: %v", err)
+			}
+
+			// Repetir la misma petición no emite otro certificado.
+			again, issued, err := ca.issueTo(tt.req, out, false)
+			if err != nil || issued || !again.Equal(cert) {
+				t.Errorf("segunda emisión = %v, emitido %v, mismo %v", err, issued, again.Equal(cert))
+			}
+
+			// Otra identidad en el mismo archivo exige -force.
+			other := tt.req
+			other.CommonName = "otro"
+			if _, _, err := ca.issueTo(other, out, false); err == nil {
+				t.Error("issueTo reemplazó otra identidad sin -force")
+			}
+			replaced, issued, err := ca.issueTo(other, out, true)
+			if err != nil || !issued || replaced.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				t.Errorf("issueTo con -force = %v, emitido %v", err, issued)
+			}
+		})
+	}
+}
+
+func TestRevokeAndRenew(t *testing.T) {
+	ca := newTestCA(t)
+	out := filepath.Join(t.TempDir(), "alice")
+	req := certRequest{Kind: kindClient, CommonName: "alice", Groups: []string{"devs"}, Validity: day}
+	cert, _, err := ca.issueTo(req, out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewedOld, renewed, err := ca.renew(out+".crt", 2*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewedOld.Equal(cert) || renewed.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Error("renew no emitió un número de serie nuevo")
+	}
+	if !bytes.Equal(renewed.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		t.Error("renew cambió la clave")
+	}
+	if renewed.Subject.CommonName != "alice" || renewed.Subject.OrganizationalUnit[0] != "devs" {
+		t.Errorf("renew cambió la identidad: %s", describe(renewed))
+	}
+
+	// -name revoca el certificado original y el renovado.
+	if err := ca.revokeName("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.revokeName("alice"); err == nil {
+		t.Error("revokeName encontró certificados vigentes ya revocados")
+	}
+	revoked, err := ca.revokedSerials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range []string{cert.SerialNumber.String(), renewed.SerialNumber.String()} {
+		if !revoked[serial] {
+			t.Errorf("la serie %s no quedó revocada", serial)
+		}
+	}
+
+	// La lista conserva el formato que leen los servidores y no repite series.
+	if err := ca.revokeFile(out + ".crt"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(ca.revokedPath())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], cert.SerialNumber.String()+" # CN=alice") {
+		t.Errorf("lista de revocados:\n%s", data)
+	}
+	if err := ca.revokeSerialString("no-es-serie"); err == nil {
+		t.Error("revokeSerialString aceptó una serie inválida")
+	}
+
+	// Un certificado revocado no cuenta como vigente: issueTo no lo devuelve
+	// como ya emitido y pide -force para reemplazarlo.
+	if _, issued, err := ca.issueTo(req, out, false); err == nil || issued {
+		t.Errorf("issueTo sobre un certificado revocado = %v, emitido %v", err, issued)
+	}
+
+	var listing bytes.Buffer
+	if err := ca.list(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(listing.String(), "revocado") != 2 {
+		t.Errorf("list no muestra los dos certificados revocados:\n%s", listing.String())
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// El inventario de certificados emitidos se guarda junto a la CA en
+// dfs-ca-index.json, y la lista de revocados en revoked_serials.txt, con el
+// formato que leen los servidores (-revoked): un número de serie decimal por
+// línea y un comentario tras "#".
+const (
+	indexFile   = "dfs-ca-index.json"
+	revokedFile = "revoked_serials.txt"
+)
+
+// issuedRecord es un certificado emitido o renovado por dfs-ca.
+type issuedRecord struct {
+	Serial      string    `json:"serial"`
+	Kind        string    `json:"kind"`
+	CommonName  string    `json:"common_name"`
+	Groups      []string  `json:"groups,omitempty"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	IssuedAt    time.Time `json:"issued_at"`
+	NotAfter    time.Time `json:"not_after"`
+	CertFile    string    `json:"cert_file"`
+}
+
+func (ca *authority) indexPath() string   { return filepath.Join(ca.Dir, indexFile) }
+func (ca *authority) revokedPath() string { return filepath.Join(ca.Dir, revokedFile) }
+
+func (ca *authority) loadIndex() ([]issuedRecord, error) {
+	data, err := os.ReadFile(ca.indexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fallo al leer '%s': %v", ca.indexPath(), err)
+	}
+	var records []issuedRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("fallo al analizar '%s': %v", ca.indexPath(), err)
+	}
+	return records, nil
+}
+
+// record agrega un certificado recién firmado al inventario.
+func (ca *authority) record(cert *x509.Certificate, certPath string) error {
+	records, err := ca.loadIndex()
+	if err != nil {
+		return err
+	}
+	rec := issuedRecord{
+		Serial:     cert.SerialNumber.String(),
+		Kind:       certKind(cert),
+		CommonName: cert.Subject.CommonName,
+		Groups:     cert.Subject.OrganizationalUnit,
+		DNSNames:   cert.DNSNames,
+		IssuedAt:   time.Now(),
+		NotAfter:   cert.NotAfter,
+		CertFile:   certPath,
+	}
+	for _, ip := range cert.IPAddresses {
+		rec.IPAddresses = append(rec.IPAddresses, ip.String())
+	}
+	records = append(records, rec)
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ca.indexPath(), data, 0644); err != nil {
+		return fmt.Errorf("fallo al escribir '%s': %v", ca.indexPath(), err)
+	}
+	return nil
+}
+
+// revokedSerials lee la lista de revocados. Sin archivo no hay revocados.
+func (ca *authority) revokedSerials() (map[string]bool, error) {
+	serials := make(map[string]bool)
+	data, err := os.ReadFile(ca.revokedPath())
+	if os.IsNotExist(err) {
+		return serials, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fallo al leer '%s': %v", ca.revokedPath(), err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			serials[line] = true
+		}
+	}
+	return serials, nil
+}
+
+// revoke agrega un número de serie a la lista de revocados, si no estaba. Los
+// servidores la recargan solos y cierran las sesiones de ese certificado.
+func (ca *authority) revoke(serial *big.Int, note string) error {
+	revoked, err := ca.revokedSerials()
+	if err != nil {
+		return err
+	}
+	if revoked[serial.String()] {
+		fmt.Printf("El certificado con serie %s ya estaba revocado.\n", serial)
+		return nil
+	}
+	file, err := os.OpenFile(ca.revokedPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("fallo al abrir '%s': %v", ca.revokedPath(), err)
+	}
+	defer file.Close()
+	line := fmt.Sprintf("%s # %s revocado el %s\n", serial, note, time.Now().Format(time.RFC3339))
+	if _, err := file.WriteString(line); err != nil {
+		return fmt.Errorf("fallo al escribir '%s': %v", ca.revokedPath(), err)
+	}
+	fmt.Printf("Certificado con serie %s revocado en %s. Copie la lista a la ruta -revoked de cada servidor.\n", serial, ca.revokedPath())
+	return nil
+}
+
+// revokeFile revoca el certificado guardado en certPath.
+func (ca *authority) revokeFile(certPath string) error {
+	cert, err := readCert(certPath)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignatureFrom(ca.Cert); err != nil {
+		return fmt.Errorf("'%s' no fue emitido por esta CA: %v", certPath, err)
+	}
+	return ca.revoke(cert.SerialNumber, "CN="+cert.Subject.CommonName)
+}
+
+// revokeSerialString revoca por número de serie decimal, para certificados
+// cuyo archivo ya no se tiene.
+func (ca *authority) revokeSerialString(value string) error {
+	serial, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return fmt.Errorf("número de serie inválido: %s", value)
+	}
+	note := "serie " + serial.String()
+	records, err := ca.loadIndex()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if rec.Serial == serial.String() {
+			note = "CN=" + rec.CommonName
+		}
+	}
+	return ca.revoke(serial, note)
+}
+
+// revokeName revoca todos los certificados vigentes del inventario con ese CN.
+func (ca *authority) revokeName(commonName string) error {
+	records, err := ca.loadIndex()
+	if err != nil {
+		return err
+	}
+	revoked, err := ca.revokedSerials()
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, rec := range records {
+		if rec.CommonName != commonName || revoked[rec.Serial] || time.Now().After(rec.NotAfter) {
+			continue
+		}
+		serial, _ := new(big.Int).SetString(rec.Serial, 10)
+		if err := ca.revoke(serial, "CN="+rec.CommonName); err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("no hay certificados vigentes con CN '%s' en el inventario", commonName)
+	}
+	return nil
+}
+
+// list muestra el inventario con el estado de cada certificado.
+func (ca *authority) list(w io.Writer) error {
+	records, err := ca.loadIndex()
+	if err != nil {
+		return err
+	}
+	revoked, err := ca.revokedSerials()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "CA: %s (vence %s)\n", ca.Cert.Subject.CommonName, ca.Cert.NotAfter.Format("2006-01-02"))
+	if warning := ca.legacyWarning(); warning != "" {
+		fmt.Fprintln(w, "Aviso:", warning)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No se ha emitido ningún certificado con dfs-ca.")
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].IssuedAt.Before(records[j].IssuedAt) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIPO\tCN\tSAN/GRUPOS\tVENCE\tESTADO\tSERIE\tARCHIVO")
+	for _, rec := range records {
+		details := append(append(append([]string(nil), rec.DNSNames...), rec.IPAddresses...), rec.Groups...)
+		status := "vigente"
+		switch {
+		case revoked[rec.Serial]:
+			status = "revocado"
+		case time.Now().After(rec.NotAfter):
+			status = "vencido"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.Kind, rec.CommonName, strings.Join(details, ","),
+			rec.NotAfter.Format("2006-01-02"), status, rec.Serial, rec.CertFile)
+	}
+	return tw.Flush()
+}
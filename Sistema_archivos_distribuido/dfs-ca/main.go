@@ -0,0 +1,209 @@
+// dfs-ca administra la CA del sistema de archivos distribuido: crea la CA,
+// emite certificados de servidor y de cliente, los renueva, los revoca y lista
+// los emitidos. Sustituye a setup_ca.go y client_ca.go.
+//
+// Ejecutar con: go run ./dfs-ca <subcomando> [opciones]
+//
+//	dfs-ca init
+//	dfs-ca issue-server -ip 10.0.0.5 -dns nodo1.example.com -out server
+//	dfs-ca issue-client -name alice -group devs -out client
+//	dfs-ca renew server.crt
+//	dfs-ca revoke client.crt | -serial <n> | -name alice
+//	dfs-ca list
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// listFlag acumula valores de una opción repetible; cada valor puede traer
+// varios elementos separados por comas.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+const usage = `Uso: dfs-ca <subcomando> [opciones]
+
+Subcomandos:
+  init                               Crea la CA (ca.crt, ca.key) si no existe.
+  issue-server -ip <ip> -dns <nombre> Emite un certificado de servidor con esos SAN.
+                                     Deben incluir la dirección con que lo contactan
+                                     peers y clientes (la IP que anuncia el nodo).
+  issue-client -name <CN> [-group <OU>] Emite un certificado de cliente.
+  renew <cert.crt>                   Reemite un certificado con la misma identidad y clave.
+  revoke <cert.crt> | -serial <n> | -name <CN>
+                                     Agrega certificados a la lista de revocados.
+  list                               Lista los certificados emitidos y su estado.
+
+Use "dfs-ca <subcomando> -h" para ver las opciones de cada uno.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "init":
+		err = runInit(args)
+	case "issue-server":
+		err = runIssue(kindServer, args)
+	case "issue-client":
+		err = runIssue(kindClient, args)
+	case "renew":
+		err = runRenew(args)
+	case "revoke":
+		err = runRevoke(args)
+	case "list":
+		err = runList(args)
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Subcomando desconocido: %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Carpeta de la CA (ca.crt, ca.key, índice y lista de revocados)")
+	cn := fs.String("cn", "My Distributed Directory Root CA", "CN de la CA")
+	years := fs.Int("years", 10, "Años de validez de la CA")
+	fs.Parse(args)
+
+	ca, created, err := initCA(*dir, *cn, time.Duration(*years)*365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("CA creada: %s (vence %s).\n", ca.Cert.Subject.CommonName, ca.Cert.NotAfter.Format("2006-01-02"))
+		return nil
+	}
+	fmt.Printf("La CA ya existe en '%s': %s (vence %s). No se modificó.\n", *dir, ca.Cert.Subject.CommonName, ca.Cert.NotAfter.Format("2006-01-02"))
+	if warning := ca.legacyWarning(); warning != "" {
+		fmt.Println("Aviso:", warning)
+	}
+	return nil
+}
+
+func runIssue(kind string, args []string) error {
+	fs := flag.NewFlagSet("issue-"+kind, flag.ExitOnError)
+	dir := fs.String("dir", ".", "Carpeta de la CA")
+	name := fs.String("name", "", "CN del certificado (en servidores, por defecto el primer -dns o -ip)")
+	out := fs.String("out", kind, "Prefijo de los archivos de salida (<out>.crt y <out>.key)")
+	days := fs.Int("days", 365, "Días de validez")
+	force := fs.Bool("force", false, "Sobrescribe un certificado existente con otra identidad")
+	var ips, dnsNames, groups listFlag
+	if kind == kindServer {
+		fs.Var(&ips, "ip", "IP del servidor (repetible o separada por comas)")
+		fs.Var(&dnsNames, "dns", "Nombre DNS del servidor (repetible o separado por comas)")
+	} else {
+		fs.Var(&groups, "group", "Grupo del cliente para las ACL, como OU (repetible o separado por comas)")
+	}
+	fs.Parse(args)
+
+	req := certRequest{
+		Kind:       kind,
+		CommonName: *name,
+		Groups:     groups,
+		DNSNames:   dnsNames,
+		Validity:   time.Duration(*days) * 24 * time.Hour,
+	}
+	if err := req.parseIPs(ips); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	cert, issued, err := ca.issueTo(req, *out, *force)
+	if err != nil {
+		return err
+	}
+	if !issued {
+		fmt.Printf("'%s.crt' ya es un certificado vigente de esta CA para %s. No se modificó.\n", *out, describe(cert))
+		return nil
+	}
+	fmt.Printf("Certificado de %s emitido: %s.crt y %s.key (%s, serie %s, vence %s).\n", kind, *out, *out, describe(cert), cert.SerialNumber, cert.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+func runRenew(args []string) error {
+	fs := flag.NewFlagSet("renew", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Carpeta de la CA")
+	days := fs.Int("days", 365, "Días de validez del certificado renovado")
+	revokeOld := fs.Bool("revoke-old", false, "Revoca el certificado anterior")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("uso: dfs-ca renew [opciones] <cert.crt>")
+	}
+
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	old, renewed, err := ca.renew(fs.Arg(0), time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("'%s' renovado: serie %s, vence %s.\n", fs.Arg(0), renewed.SerialNumber, renewed.NotAfter.Format("2006-01-02"))
+	if *revokeOld {
+		return ca.revoke(old.SerialNumber, fmt.Sprintf("CN=%s renovado", old.Subject.CommonName))
+	}
+	return nil
+}
+
+func runRevoke(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Carpeta de la CA")
+	serial := fs.String("serial", "", "Número de serie (decimal) a revocar")
+	name := fs.String("name", "", "Revoca todos los certificados vigentes con este CN")
+	fs.Parse(args)
+
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() == 1 && *serial == "" && *name == "":
+		return ca.revokeFile(fs.Arg(0))
+	case fs.NArg() == 0 && *serial != "" && *name == "":
+		return ca.revokeSerialString(*serial)
+	case fs.NArg() == 0 && *serial == "" && *name != "":
+		return ca.revokeName(*name)
+	}
+	return fmt.Errorf("uso: dfs-ca revoke [-dir <carpeta>] <cert.crt> | -serial <n> | -name <CN>")
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Carpeta de la CA")
+	fs.Parse(args)
+
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	return ca.list(os.Stdout)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// failoverStagger separa los turnos de los candidatos a nuevo dueño. El de
+// dirección menor actúa primero; los siguientes sólo lo hacen si, pasado su
+// turno, la entrada sigue apuntando al dueño muerto.
+const failoverStagger = 5 * time.Second
+
+// failoverCandidates devuelve, en orden de prioridad, los nodos que pueden
+// heredar la entrada de un dueño muerto: sus réplicas, de menor a mayor dirección.
+func failoverCandidates(entry DirectoryEntry, deadOwner string) []string {
+	var candidates []string
+	for _, holder := range entry.Replicas {
+		if holder != deadOwner {
+			candidates = append(candidates, holder)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// failoverFilesOf elige un dueño nuevo para cada archivo vivo de un peer que se
+// declaró muerto. Cada nodo evalúa sólo su propia candidatura: si guarda una
+// réplica de la versión más reciente, espera su turno y asume la propiedad.
+func failoverFilesOf(deadOwner string) {
+	sharedFilesMutex.RLock()
+	turns := make(map[string]int)
+	for name, entry := range sharedFiles {
+		if entry.OwnerIP != deadOwner || entry.Deleted {
+			continue
+		}
+		if _, valid := replicas.Path(entry); !valid {
+			continue
+		}
+		for rank, candidate := range failoverCandidates(entry, deadOwner) {
+			if candidate == selfAddr {
+				turns[name] = rank
+			}
+		}
+	}
+	sharedFilesMutex.RUnlock()
+
+	if len(turns) == 0 {
+		return
+	}
+	logEvent("FAILOVER", "ELECTION", fmt.Sprintf("El dueño %s murió. Este nodo es candidato para %d archivos.", deadOwner, len(turns)))
+
+	var wg sync.WaitGroup
+	for name, rank := range turns {
+		wg.Add(1)
+		go func(fileName string, rank int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(rank) * failoverStagger)
+			takeOwnership(fileName, deadOwner)
+		}(name, rank)
+	}
+	wg.Wait()
+}
+
+// takeOwnership convierte la réplica local en el archivo propio y anuncia el
+// cambio de dueño con una versión mayor, para que las escrituras puedan seguir.
+func takeOwnership(fileName, deadOwner string) {
+	if gossipProtocol.IsPeer(deadOwner) {
+		logEvent("FAILOVER", "ABORTED", fmt.Sprintf("%s volvió a responder; no se reasigna '%s'.", deadOwner, fileName))
+		return
+	}
+
+	sharedFilesMutex.Lock()
+	entry, found := sharedFiles[fileName]
+	if !found || entry.Deleted || entry.OwnerIP != deadOwner {
+		sharedFilesMutex.Unlock()
+		return
+	}
+	replicaPath, valid := replicas.Path(entry)
+	if !valid {
+		sharedFilesMutex.Unlock()
+		return
+	}
+	content, err := os.ReadFile(replicaPath)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(localPath(fileName)), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(localPath(fileName), content, 0644)
+	}
+	if err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("FAILOVER", "ERROR", fmt.Sprintf("Falla al restaurar '%s' desde la réplica: %v", fileName, err))
+		return
+	}
+
+	entry.OwnerIP = selfAddr
+	entry.Unavailable = false
+	entry.ModificationDate = fileModTime(fileName)
+	var remaining []string
+	for _, holder := range entry.Replicas {
+		if holder != selfAddr && holder != deadOwner {
+			remaining = append(remaining, holder)
+		}
+	}
+	entry.Replicas = remaining
+	bumpVersion(&entry, selfAddr)
+	assignReplicas(&entry)
+	sharedFiles[fileName] = entry
+	dirStore.Put(entry)
+	versions.Record(fileName, entry, content)
+	replicas.Forget(fileName)
+	sharedFilesMutex.Unlock()
+
+	logEvent("FAILOVER", "OWNER_ELECTED", fmt.Sprintf("Este nodo es el nuevo dueño de '%s' (antes %s). Versión: %d", fileName, deadOwner, entry.Version))
+	gossipProtocol.AnnounceOwnership(entry)
+	replicateFile(entry, content)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Cada registro DTLS transporta una cabecera fija seguida de un fragmento del
+// NetworkMessage serializado. La cabecera contiene la longitud total del mensaje,
+// el número de secuencia del fragmento y un identificador aleatorio del mensaje,
+// lo que permite reensamblar mensajes más grandes que un solo datagrama.
+//
+// Como UDP puede perder o reordenar registros, los mensajes se envían con
+// go-back-N: el emisor envía hasta frameWindow registros y espera un ACK con el
+// siguiente número de secuencia esperado; si no llega, retransmite desde la base
+// de la ventana. Toda ventana se confirma, también la última y la única de los
+// mensajes cortos, y el receptor repite el ACK acumulado cada vez que recibe un
+// registro duplicado o fuera de orden, así que un ACK perdido se recupera con la
+// siguiente retransmisión. Para no entregar dos veces un mensaje cuyo ACK final
+// se perdió, cada conexión recuerda los últimos mensajes recibidos completos y
+// sólo vuelve a confirmarlos.
+const (
+	frameHeaderSize     = 12
+	frameChunkSize      = 1024             // Cabe holgadamente en el MTU por defecto de pion/dtls (1200).
+	frameWindow         = 16               // Registros enviados antes de esperar un ACK.
+	frameAckMarker      = 0xFFFFFFFF       // Valor del campo de longitud que identifica un ACK.
+	frameAckTimeout     = 1 * time.Second  // Espera máxima por un ACK antes de retransmitir.
+	frameMaxRetries     = 8                // Retransmisiones permitidas por ventana.
+	maxFrameMessage     = 64 * 1024 * 1024 // Límite para no reservar memoria arbitraria.
+	frameRecordBuffer   = 2 * (frameHeaderSize + frameChunkSize)
+	frameRecentMessages = 8                // Mensajes completos recordados por conexión.
+	frameMaxPending     = 2 * frameWindow  // Registros de datos guardados mientras se espera un ACK.
+	frameStateIdle      = 10 * time.Minute // Tras este tiempo sin uso se olvida el estado de una conexión.
+)
+
+// frameConn es el estado del protocolo que una conexión conserva entre
+// llamadas. Una conexión la usa una sola goroutine a la vez.
+type frameConn struct {
+	recent   map[uint32]uint32 // ID de un mensaje recibido completo -> sus registros.
+	order    []uint32          // IDs de recent, del más antiguo al más nuevo.
+	pending  [][]byte          // Registros de datos leídos mientras se esperaba un ACK.
+	deadline time.Time         // Plazo de lectura fijado por quien usa la conexión.
+	lastUsed time.Time
+}
+
+var (
+	frameConnsMutex sync.Mutex
+	frameConns      = make(map[net.Conn]*frameConn)
+	frameLastSweep  time.Time
+)
+
+// frameStateFor devuelve el estado de conn y, de paso, olvida el de las
+// conexiones que llevan frameStateIdle sin usarse.
+func frameStateFor(conn net.Conn) *frameConn {
+	frameConnsMutex.Lock()
+	defer frameConnsMutex.Unlock()
+	now := time.Now()
+	if now.Sub(frameLastSweep) > frameStateIdle {
+		for c, state := range frameConns {
+			if now.Sub(state.lastUsed) > frameStateIdle {
+				delete(frameConns, c)
+			}
+		}
+		frameLastSweep = now
+	}
+	state, found := frameConns[conn]
+	if !found {
+		state = &frameConn{recent: make(map[uint32]uint32)}
+		frameConns[conn] = state
+	}
+	state.lastUsed = now
+	return state
+}
+
+// forgetFrameConn descarta el estado de una conexión que ya no sirve.
+func forgetFrameConn(conn net.Conn) {
+	frameConnsMutex.Lock()
+	delete(frameConns, conn)
+	frameConnsMutex.Unlock()
+}
+
+// setReadDeadline fija el plazo de lectura de conn y lo recuerda para que
+// writeMessage lo restaure después de esperar sus ACK.
+func setReadDeadline(conn net.Conn, t time.Time) error {
+	frameStateFor(conn).deadline = t
+	return conn.SetReadDeadline(t)
+}
+
+// remember registra un mensaje recibido completo.
+func (state *frameConn) remember(id, records uint32) {
+	if _, found := state.recent[id]; found {
+		return
+	}
+	state.recent[id] = records
+	state.order = append(state.order, id)
+	if len(state.order) > frameRecentMessages {
+		delete(state.recent, state.order[0])
+		state.order = state.order[1:]
+	}
+}
+
+// readRecord devuelve primero los registros guardados mientras se esperaba un
+// ACK y después lee de la conexión.
+func (state *frameConn) readRecord(conn net.Conn, buffer []byte) (int, error) {
+	if len(state.pending) > 0 {
+		n := copy(buffer, state.pending[0])
+		state.pending = state.pending[1:]
+		return n, nil
+	}
+	return conn.Read(buffer)
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// frameRecordCount devuelve cuántos registros ocupa un mensaje de total bytes.
+func frameRecordCount(total uint32) uint32 {
+	if total == 0 {
+		return 1
+	}
+	return (total + frameChunkSize - 1) / frameChunkSize
+}
+
+func putFrameHeader(record []byte, total, seq, id uint32) {
+	binary.BigEndian.PutUint32(record[0:4], total)
+	binary.BigEndian.PutUint32(record[4:8], seq)
+	binary.BigEndian.PutUint32(record[8:12], id)
+}
+
+func parseFrameHeader(record []byte) (total, seq, id uint32) {
+	return binary.BigEndian.Uint32(record[0:4]), binary.BigEndian.Uint32(record[4:8]), binary.BigEndian.Uint32(record[8:12])
+}
+
+// writeMessage serializa el mensaje, lo envía fragmentado en uno o más
+// registros y espera a que se confirme. Mientras espera los ACK cambia el plazo
+// de lectura de conn y al terminar restaura el fijado con setReadDeadline.
+func writeMessage(conn net.Conn, msg NetworkMessage) error {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("fallo al serializar el mensaje: %v", err)
+	}
+	if len(msgBytes) > maxFrameMessage {
+		return fmt.Errorf("mensaje de %d bytes excede el máximo permitido", len(msgBytes))
+	}
+
+	state := frameStateFor(conn)
+	defer conn.SetReadDeadline(state.deadline)
+	id := rand.Uint32()
+	total := uint32(len(msgBytes))
+	records := frameRecordCount(total)
+	record := make([]byte, frameHeaderSize+frameChunkSize)
+	sendRecord := func(seq uint32) error {
+		start := int(seq) * frameChunkSize
+		end := start + frameChunkSize
+		if end > len(msgBytes) {
+			end = len(msgBytes)
+		}
+		putFrameHeader(record, total, seq, id)
+		n := copy(record[frameHeaderSize:], msgBytes[start:end])
+		_, err := conn.Write(record[:frameHeaderSize+n])
+		return err
+	}
+
+	var base uint32
+	retries := 0
+	for base < records {
+		windowEnd := base + frameWindow
+		if windowEnd > records {
+			windowEnd = records
+		}
+		for seq := base; seq < windowEnd; seq++ {
+			if err := sendRecord(seq); err != nil {
+				return fmt.Errorf("fallo al escribir el fragmento %d: %v", seq, err)
+			}
+		}
+
+		next, err := state.awaitAck(conn, id, base, records)
+		if err != nil {
+			forgetFrameConn(conn)
+			return fmt.Errorf("fallo al esperar ACK del fragmento %d: %v", base, err)
+		}
+		if next > base {
+			base = next
+			retries = 0
+			continue
+		}
+		retries++
+		if retries > frameMaxRetries {
+			return fmt.Errorf("sin ACK para el fragmento %d tras %d reintentos", base, frameMaxRetries)
+		}
+	}
+	return nil
+}
+
+// awaitAck espera hasta frameAckTimeout un ACK del mensaje id que avance la
+// ventana y devuelve el siguiente número de secuencia esperado, o base si no
+// llegó. Mientras tanto vuelve a confirmar las retransmisiones de mensajes ya
+// recibidos y guarda los registros de datos de un mensaje nuevo del otro
+// extremo para el próximo readMessage.
+func (state *frameConn) awaitAck(conn net.Conn, id, base, records uint32) (uint32, error) {
+	conn.SetReadDeadline(time.Now().Add(frameAckTimeout))
+	buffer := make([]byte, frameRecordBuffer)
+	for {
+		n, err := conn.Read(buffer)
+		if isTimeout(err) {
+			return base, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n < frameHeaderSize {
+			continue
+		}
+		recTotal, recSeq, recID := parseFrameHeader(buffer)
+		if recTotal == frameAckMarker {
+			// Los ACK de otros mensajes o que no avanzan son tardíos.
+			if recID == id && recSeq > base && recSeq <= records {
+				return recSeq, nil
+			}
+			continue
+		}
+		if done, found := state.recent[recID]; found {
+			writeFrameAck(conn, done, recID)
+			continue
+		}
+		if len(state.pending) < frameMaxPending {
+			state.pending = append(state.pending, append([]byte(nil), buffer[:n]...))
+		}
+	}
+}
+
+// writeFrameAck confirma al emisor del mensaje id el siguiente número de
+// secuencia esperado.
+func writeFrameAck(conn net.Conn, next, id uint32) error {
+	ack := make([]byte, frameHeaderSize)
+	putFrameHeader(ack, frameAckMarker, next, id)
+	_, err := conn.Write(ack)
+	return err
+}
+
+// readMessage lee registros hasta reensamblar un NetworkMessage completo.
+// El plazo de lectura debe fijarlo quien llama con setReadDeadline.
+func readMessage(conn net.Conn) (NetworkMessage, error) {
+	var (
+		msgBytes []byte
+		msgID    uint32
+		total    uint32
+		records  uint32
+		expected uint32
+	)
+	state := frameStateFor(conn)
+	record := make([]byte, frameRecordBuffer)
+	for {
+		n, err := state.readRecord(conn, record)
+		if err != nil {
+			if !isTimeout(err) {
+				forgetFrameConn(conn)
+			}
+			return NetworkMessage{}, err
+		}
+		if n < frameHeaderSize {
+			return NetworkMessage{}, fmt.Errorf("registro de %d bytes demasiado corto para la cabecera", n)
+		}
+		recTotal, recSeq, recID := parseFrameHeader(record)
+		if recTotal == frameAckMarker {
+			// ACK tardío de un mensaje anterior; no pertenece a este.
+			continue
+		}
+		if done, found := state.recent[recID]; found {
+			// Retransmisión de un mensaje ya entregado: se perdió su ACK final.
+			writeFrameAck(conn, done, recID)
+			continue
+		}
+
+		if expected == 0 && recSeq == 0 {
+			if recTotal > maxFrameMessage {
+				return NetworkMessage{}, fmt.Errorf("mensaje anunciado de %d bytes excede el máximo permitido", recTotal)
+			}
+			msgID = recID
+			total = recTotal
+			records = frameRecordCount(total)
+			msgBytes = make([]byte, 0, total)
+		} else if expected == 0 || recID != msgID || recTotal != total || recSeq != expected {
+			// Registro perdido, duplicado o reordenado: se descarta y se repite
+			// el ACK acumulado para que el emisor retransmita desde ahí, por si
+			// el anterior se perdió.
+			if expected > 0 && recID == msgID {
+				writeFrameAck(conn, expected, msgID)
+			}
+			continue
+		}
+
+		msgBytes = append(msgBytes, record[frameHeaderSize:n]...)
+		expected++
+		if uint32(len(msgBytes)) > total {
+			return NetworkMessage{}, fmt.Errorf("se recibieron más bytes (%d) de los anunciados (%d)", len(msgBytes), total)
+		}
+		if expected == records {
+			state.remember(msgID, records)
+			// El ACK final se duplica: si ambos se pierden, el emisor sólo se
+			// entera cuando vuelva a leerse esta conexión.
+			writeFrameAck(conn, expected, msgID)
+			writeFrameAck(conn, expected, msgID)
+			break
+		}
+		if expected%frameWindow == 0 {
+			writeFrameAck(conn, expected, msgID)
+		}
+	}
+
+	var msg NetworkMessage
+	if err := json.Unmarshal(msgBytes, &msg); err != nil {
+		return NetworkMessage{}, fmt.Errorf("fallo al deserializar el mensaje: %v", err)
+	}
+	return msg, nil
+}
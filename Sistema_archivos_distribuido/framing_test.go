@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn es un extremo de un par de conexiones de datagramas en memoria.
+// drop decide si un datagrama enviado se pierde.
+type lossyConn struct {
+	in, out  chan []byte
+	drop     func(datagram []byte) bool
+	mutex    sync.Mutex
+	deadline time.Time
+}
+
+func lossyPipe(dropAtoB, dropBtoA func([]byte) bool) (*lossyConn, *lossyConn) {
+	ab, ba := make(chan []byte, 4096), make(chan []byte, 4096)
+	return &lossyConn{in: ba, out: ab, drop: dropAtoB}, &lossyConn{in: ab, out: ba, drop: dropBtoA}
+}
+
+func (c *lossyConn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case datagram := <-c.in:
+		return copy(b, datagram), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	drop := c.drop != nil && c.drop(b)
+	c.mutex.Unlock()
+	if !drop {
+		c.out <- append([]byte(nil), b...)
+	}
+	return len(b), nil
+}
+
+func (c *lossyConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *lossyConn) Close() error                     { return nil }
+func (c *lossyConn) LocalAddr() net.Addr              { return &net.UDPAddr{} }
+func (c *lossyConn) RemoteAddr() net.Addr             { return &net.UDPAddr{} }
+func (c *lossyConn) SetDeadline(t time.Time) error    { return c.SetReadDeadline(t) }
+func (c *lossyConn) SetWriteDeadline(time.Time) error { return nil }
+
+func isAck(datagram []byte) bool {
+	return binary.BigEndian.Uint32(datagram[0:4]) == frameAckMarker
+}
+
+// dropFirst pierde los primeros n datagramas que cumplan match.
+func dropFirst(n int, match func([]byte) bool) func([]byte) bool {
+	return func(datagram []byte) bool {
+		if n > 0 && match(datagram) {
+			n--
+			return true
+		}
+		return false
+	}
+}
+
+// dropEvery pierde uno de cada n registros de datos.
+func dropEvery(n int) func([]byte) bool {
+	count := 0
+	return func(datagram []byte) bool {
+		if isAck(datagram) {
+			return false
+		}
+		count++
+		return count%n == 0
+	}
+}
+
+func isData(datagram []byte) bool { return !isAck(datagram) }
+
+func TestFramingWithLoss(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 3000) // Unas 70 ventanas de registros.
+	tests := []struct {
+		name        string
+		payload     []byte
+		dropData    func([]byte) bool // Del emisor al receptor.
+		dropAck     func([]byte) bool // Del receptor al emisor.
+		wantWriteOK bool
+	}{
+		{"corto sin pérdidas", []byte("hola"), nil, nil, true},
+		{"corto pierde el registro", []byte("hola"), dropFirst(1, isData), nil, true},
+		{"corto pierde el primer ACK", []byte("hola"), nil, dropFirst(1, isAck), true},
+		{"corto pierde los dos ACK finales", []byte("hola"), nil, dropFirst(2, isAck), true},
+		{"largo sin pérdidas", large, nil, nil, true},
+		{"largo pierde el primer ACK", large, nil, dropFirst(1, isAck), true},
+		{"largo pierde registros", large, dropEvery(7), nil, true},
+		{"largo pierde registros y ACK", large, dropEvery(11), dropFirst(3, isAck), true},
+		{"sin ningún ACK", []byte("hola"), nil, func([]byte) bool { return true }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, reader := lossyPipe(tt.dropData, tt.dropAck)
+			sent := []NetworkMessage{{Type: "PRIMERO", Payload: tt.payload}, {Type: "SEGUNDO", Payload: []byte("fin")}}
+			writeErr := make(chan error, 1)
+			go func() {
+				for _, msg := range sent {
+					if err := writeMessage(writer, msg); err != nil {
+						writeErr <- err
+						return
+					}
+				}
+				writeErr <- nil
+			}()
+
+			// El receptor sigue leyendo, como handleClient: así responde a las
+			// retransmisiones de un mensaje cuyo ACK final se perdió.
+			for i, want := range sent {
+				reader.SetReadDeadline(time.Now().Add(15 * time.Second))
+				got, err := readMessage(reader)
+				if !tt.wantWriteOK {
+					if i == 0 && (err != nil || got.Type != want.Type) {
+						t.Fatalf("readMessage = %v, %v; se esperaba %s", got.Type, err, want.Type)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("readMessage %d: %v", i, err)
+				}
+				if got.Type != want.Type || !bytes.Equal(got.Payload, want.Payload) {
+					t.Fatalf("mensaje %d = %s (%d bytes); se esperaba %s (%d bytes)", i, got.Type, len(got.Payload), want.Type, len(want.Payload))
+				}
+			}
+			if !tt.wantWriteOK {
+				// Sin lector activo el emisor debe rendirse en lugar de colgarse.
+				if err := <-writeErr; err == nil {
+					t.Fatal("writeMessage no falló aunque se perdieron todos los ACK")
+				}
+				return
+			}
+			if err := <-writeErr; err != nil {
+				t.Fatalf("writeMessage: %v", err)
+			}
+		})
+	}
+}
+
+// TestWriteMessageRestoresDeadline comprueba que esperar los ACK no cambia el
+// plazo de lectura que fijó quien usa la conexión.
+func TestWriteMessageRestoresDeadline(t *testing.T) {
+	writer, reader := lossyPipe(nil, nil)
+	deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+	setReadDeadline(writer, deadline)
+	go func() {
+		reader.SetReadDeadline(time.Now().Add(5 * time.Second))
+		readMessage(reader)
+	}()
+	if err := writeMessage(writer, NetworkMessage{Type: "PRUEBA"}); err != nil {
+		t.Fatal(err)
+	}
+	writer.mutex.Lock()
+	got := writer.deadline
+	writer.mutex.Unlock()
+	if !got.Equal(deadline) {
+		t.Errorf("plazo de lectura tras writeMessage = %v; se esperaba %v", got, deadline)
+	}
+}
@@ -2,8 +2,9 @@ module distributed_directory
 
 go 1.22.2
 
+require github.com/pion/dtls/v2 v2.2.12
+
 require (
-	github.com/pion/dtls/v2 v2.2.12 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/transport/v2 v2.2.4 // indirect
 	golang.org/x/crypto v0.18.0 // indirect
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -13,17 +16,24 @@ import (
 
 // PeerState guarda la información de cada peer conocido.
 type PeerState struct {
-	LastSeen time.Time
+	LastSeen     time.Time
+	Incarnation  uint64    // Última encarnación conocida del peer (ver swim.go).
+	Suspect      bool      // El peer no respondió a un sondeo directo ni indirecto.
+	SuspectSince time.Time // Momento en que pasó a sospechoso.
 }
 
 // GossipProtocol gestiona la comunicación con otros nodos.
 type GossipProtocol struct {
-	Peers           map[string]PeerState
-	mu              sync.RWMutex
-	dtlsConfig      *dtls.Config
-	selfAddr        string
-	knownListenAddrs []string 
-	heartbeatTimeout time.Duration
+	Peers            map[string]PeerState
+	mu               sync.RWMutex
+	dtlsConfig       *dtls.Config
+	selfAddr         string
+	seeds            []string // Nodos de -peers a los que se envía JOIN al arrancar.
+	suspicionTimeout time.Duration
+	incarnation      uint64                    // Encarnación propia; sube para refutar sospechas.
+	dead             map[string]deadMember     // Peers declarados muertos, para no revivirlos por listas viejas.
+	updates          map[string]*pendingUpdate // Cambios de estado pendientes de difundir.
+	probeQueue       []string                  // Orden de sondeo de la ronda actual.
 }
 
 // NewGossipProtocol crea un nuevo protocolo e inicializa la configuración DTLS.
@@ -32,8 +42,13 @@ func NewGossipProtocol(peers []string, dtlsConfig *dtls.Config, selfAddr string)
 		Peers:            make(map[string]PeerState),
 		dtlsConfig:       dtlsConfig,
 		selfAddr:         selfAddr,
-		knownListenAddrs: peers,
-		heartbeatTimeout: 60 * time.Second,
+		seeds:            peers,
+		suspicionTimeout: swimSuspicionTimeout,
+		// La hora de arranque como encarnación inicial hace que un nodo reiniciado
+		// supere a la que tenía cuando se le declaró muerto.
+		incarnation: uint64(time.Now().Unix()),
+		dead:        make(map[string]deadMember),
+		updates:     make(map[string]*pendingUpdate),
 	}
 	for _, peer := range peers {
 		if peer != selfAddr {
@@ -43,21 +58,16 @@ func NewGossipProtocol(peers []string, dtlsConfig *dtls.Config, selfAddr string)
 	return gp, nil
 }
 
-// AddPeer ahora filtra por direcciones de escucha conocidas para evitar puertos efímeros.
+// AddPeer registra un contacto directo con peerAddr. Sólo debe llamarse con
+// direcciones de escucha (de JOIN, MEMBERSHIP o de un peer al que se conectó
+// este nodo), nunca con el puerto efímero de una conexión entrante.
 func (gp *GossipProtocol) AddPeer(peerAddr string) {
-	isKnownPeer := false
-	for _, known := range gp.knownListenAddrs {
-		if peerAddr == known {
-			isKnownPeer = true
-			break
-		}
-	}
-	if !isKnownPeer {
-		return
-	}
-
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
+	if _, dead := gp.dead[peerAddr]; dead {
+		// Sólo vuelve con una encarnación nueva (JOIN o refutación).
+		return
+	}
 	if peerAddr != gp.selfAddr {
 		if _, exists := gp.Peers[peerAddr]; !exists {
 			gp.Peers[peerAddr] = PeerState{LastSeen: time.Now()}
@@ -70,6 +80,17 @@ func (gp *GossipProtocol) AddPeer(peerAddr string) {
 	}
 }
 
+// PeerAddrs devuelve las direcciones de todos los peers conocidos.
+func (gp *GossipProtocol) PeerAddrs() []string {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	peerList := make([]string, 0, len(gp.Peers))
+	for peer := range gp.Peers {
+		peerList = append(peerList, peer)
+	}
+	return peerList
+}
+
 // GetRandomPeers devuelve un subconjunto aleatorio de direcciones de pares.
 func (gp *GossipProtocol) GetRandomPeers(n int) []string {
 	gp.mu.RLock()
@@ -110,40 +131,44 @@ func (gp *GossipProtocol) SendUpdate(entry DirectoryEntry, action string) {
 				Type:    action,
 				Payload: payloadBytes,
 			}
-			msgBytes, _ := json.Marshal(msg)
-			conn.Write(msgBytes)
+			if err := writeMessage(conn, msg); err != nil {
+				logEvent("GOSSIP", "ERROR", fmt.Sprintf("Falla al enviar %s a %s: %v", action, addr, err))
+			}
 		}(peerAddr)
 	}
 }
 
-// GossipUpdateAllPeers envía una actualización a todos los peers conocidos.
+// GossipUpdateAllPeers difunde una actualización a todo el cluster como rumor.
 func (gp *GossipProtocol) GossipUpdateAllPeers(entry DirectoryEntry) {
-	gp.mu.RLock()
-	peers := make([]string, 0, len(gp.Peers))
-	for peer := range gp.Peers {
-		peers = append(peers, peer)
-	}
-	gp.mu.RUnlock()
+	gp.SpreadRumor("GOSSIP_UPDATE", entry)
+}
 
-	payloadBytes, _ := json.Marshal(entry)
-	msg := NetworkMessage{
-		Type:    "GOSSIP_UPDATE",
-		Payload: payloadBytes,
-	}
-	msgBytes, _ := json.Marshal(msg)
+// AnnounceOwnership difunde a todo el cluster que una entrada cambió de dueño.
+func (gp *GossipProtocol) AnnounceOwnership(entry DirectoryEntry) {
+	gp.SpreadRumor("FILE_COPY_UPDATE", entry)
+}
 
-	for _, peerAddr := range peers {
-		go func(addr string) {
-			conn, err := gp.connectToPeer(addr)
-			if err != nil {
-				logEvent("GOSSIP", "ERROR", fmt.Sprintf("Falla al conectar para chismorreo con %s: %v", addr, err))
-				return
+// dialConfig devuelve una copia de la configuración DTLS para conectarse a
+// peerAddr que exige un certificado emitido para su host. pion/dtls no
+// comprueba ServerName cuando es una IP, que es como se anuncian los nodos,
+// así que el host se verifica también en VerifyPeerCertificate.
+func (gp *GossipProtocol) dialConfig(peerAddr string) *dtls.Config {
+	config := *gp.dtlsConfig
+	host, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		host = peerAddr
+	}
+	config.ServerName = host
+	verifyChain := gp.dtlsConfig.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if verifyChain != nil {
+			if err := verifyChain(rawCerts, chains); err != nil {
+				return err
 			}
-			defer conn.Close()
-			conn.Write(msgBytes)
-			logEvent("GOSSIP", "SEND_UPDATE", fmt.Sprintf("Enviando GOSSIP_UPDATE para '%s' a %s", entry.FileName, addr))
-		}(peerAddr)
+		}
+		return verifyPeerHost(rawCerts, host)
 	}
+	return &config
 }
 
 // connectToPeer es una función auxiliar para establecer una conexión DTLS
@@ -152,13 +177,31 @@ func (gp *GossipProtocol) connectToPeer(peerAddr string) (net.Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("falla al resolver dirección de peer %s: %v", peerAddr, err)
 	}
-	conn, err := dtls.Dial("udp", peerUDPAddr, gp.dtlsConfig)
+	conn, err := dtls.Dial("udp", peerUDPAddr, gp.dialConfig(peerAddr))
 	if err != nil {
 		return nil, fmt.Errorf("falla al conectar con peer %s: %v", peerAddr, err)
 	}
 	return conn, nil
 }
 
+// connectToPeerWithin es connectToPeer con un límite para el handshake, para
+// los sondeos de fallos, que no pueden esperar el tiempo por defecto de DTLS.
+func (gp *GossipProtocol) connectToPeerWithin(peerAddr string, timeout time.Duration) (net.Conn, error) {
+	peerUDPAddr, err := net.ResolveUDPAddr("udp", peerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("falla al resolver dirección de peer %s: %v", peerAddr, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := dtls.DialWithContext(ctx, "udp", peerUDPAddr, gp.dialConfig(peerAddr))
+	if err != nil {
+		return nil, fmt.Errorf("falla al conectar con peer %s: %v", peerAddr, err)
+	}
+	return conn, nil
+}
+
+// errNotOwner indica que el peer respondió, pero no es dueño del archivo.
+var errNotOwner = errors.New("respuesta no autoritativa o NACK recibida")
 
 // RequestStatus solicita el estado de un archivo a un peer específico.
 func (gp *GossipProtocol) RequestStatus(fileName string, peerAddr string) (*DirectoryEntry, error) {
@@ -173,71 +216,113 @@ func (gp *GossipProtocol) RequestStatus(fileName string, peerAddr string) (*Dire
 		Type:    "REQUEST_STATUS",
 		Payload: payloadBytes,
 	}
-	msgBytes, _ := json.Marshal(msg)
-	conn.Write(msgBytes)
+	if err := writeMessage(conn, msg); err != nil {
+		return nil, fmt.Errorf("falla al enviar petición a peer %s: %v", peerAddr, err)
+	}
 
-	buffer := make([]byte, 2048)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := conn.Read(buffer)
+	setReadDeadline(conn, time.Now().Add(5*time.Second))
+	responseMsg, err := readMessage(conn)
 	if err != nil {
 		return nil, fmt.Errorf("falla al recibir respuesta de peer %s: %v", peerAddr, err)
 	}
 
-	var responseMsg NetworkMessage
-	json.Unmarshal(buffer[:n], &responseMsg)
-
 	if responseMsg.Type == "STATUS_RESPONSE" && responseMsg.Authoritative {
 		var entry DirectoryEntry
 		json.Unmarshal(responseMsg.Payload, &entry)
 		return &entry, nil
 	}
-	return nil, fmt.Errorf("respuesta no autoritativa o NACK recibida")
+	return nil, errNotOwner
 }
 
-// SendHeartbeat envía un mensaje de HEARTBEAT a un subconjunto aleatorio de peers.
-func (gp *GossipProtocol) SendHeartbeat() {
-	peersToSend := gp.GetRandomPeers(3) 
-	for _, peerAddr := range peersToSend {
-		go func(addr string) {
-			logEvent("HEARTBEAT", "SEND", fmt.Sprintf("Enviando heartbeat a %s", addr))
-			conn, err := gp.connectToPeer(addr)
-			if err != nil {
-				logEvent("HEARTBEAT", "ERROR", fmt.Sprintf("Falla al enviar heartbeat a peer %s: %v", addr, err))
-				return
-			}
-			defer conn.Close()
+// Forward reenvía un mensaje a otro nodo (normalmente el dueño de un archivo) y
+// devuelve su respuesta tal cual, para que el nodo local la retransmita al cliente.
+func (gp *GossipProtocol) Forward(peerAddr string, msg NetworkMessage) (NetworkMessage, error) {
+	conn, err := gp.connectToPeer(peerAddr)
+	if err != nil {
+		return NetworkMessage{}, err
+	}
+	defer conn.Close()
 
-			msg := NetworkMessage{
-				Type:    "HEARTBEAT",
-				Payload: []byte{},
+	if err := writeMessage(conn, msg); err != nil {
+		return NetworkMessage{}, fmt.Errorf("falla al reenviar %s a %s: %v", msg.Type, peerAddr, err)
+	}
+	setReadDeadline(conn, time.Now().Add(30*time.Second))
+	responseMsg, err := readMessage(conn)
+	if err != nil {
+		return NetworkMessage{}, fmt.Errorf("falla al recibir respuesta de %s: %v", peerAddr, err)
+	}
+	return responseMsg, nil
+}
+
+// statusSurvey resume las respuestas de los peers a REQUEST_STATUS para un archivo.
+type statusSurvey struct {
+	Asked         int              // Peers consultados.
+	Nacks         int              // Respuestas no autoritativas: el peer no es dueño.
+	Failures      int              // Peers que no respondieron.
+	Authoritative []DirectoryEntry // Entradas de peers que se declaran dueños.
+}
+
+// SurveyStatus consulta en paralelo a todos los peers vivos por el estado de
+// un archivo y espera todas las respuestas.
+func (gp *GossipProtocol) SurveyStatus(fileName string) statusSurvey {
+	peers := gp.PeerAddrs()
+	survey := statusSurvey{Asked: len(peers)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peerAddr := range peers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			entry, err := gp.RequestStatus(fileName, addr)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				survey.Authoritative = append(survey.Authoritative, *entry)
+			case errors.Is(err, errNotOwner):
+				survey.Nacks++
+			default:
+				survey.Failures++
+				logEvent("SERVER_CLEANER", "STATUS_ERROR", fmt.Sprintf("Sin respuesta de %s sobre '%s': %v", addr, fileName, err))
 			}
-			msgBytes, _ := json.Marshal(msg)
-			conn.Write(msgBytes)
 		}(peerAddr)
 	}
+	wg.Wait()
+	return survey
 }
 
-// CheckDeadPeers elimina los peers que no han enviado un heartbeat en un tiempo.
+// CheckDeadPeers declara muertos a los peers que siguen sospechosos pasado
+// suspicionTimeout sin refutarlo, los elimina de la lista y lanza la elección
+// de nuevo dueño para los archivos que eran suyos.
 func (gp *GossipProtocol) CheckDeadPeers() {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 	for peerAddr, state := range gp.Peers {
-		if time.Since(state.LastSeen) > gp.heartbeatTimeout {
-			logEvent("HEARTBEAT", "PEER_DEAD", fmt.Sprintf("Peer %s considerado muerto. Eliminando de la lista.", peerAddr))
-			delete(gp.Peers, peerAddr)
+		if state.Suspect && time.Since(state.SuspectSince) > gp.suspicionTimeout {
+			gp.applyUpdate(MemberInfo{Addr: peerAddr, Incarnation: state.Incarnation, Status: memberDead}, gp.selfAddr)
+		}
+	}
+	for peerAddr, record := range gp.dead {
+		if time.Since(record.Since) > swimDeadRetention {
+			delete(gp.dead, peerAddr)
 		}
 	}
 }
 
-// StartGossipRoutine ahora también inicia la rutina de heartbeats
+// IsPeer indica si peerAddr sigue en la lista de peers vivos.
+func (gp *GossipProtocol) IsPeer(peerAddr string) bool {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	_, alive := gp.Peers[peerAddr]
+	return alive
+}
+
+// StartGossipRoutine ejecuta las rondas de chismes y el detector de fallos.
 func (gp *GossipProtocol) StartGossipRoutine() {
-	gossipTicker := time.NewTicker(20 * time.Second)
-	heartbeatTicker := time.NewTicker(10 * time.Second)
-	cleanupTicker := time.NewTicker(30 * time.Second)
+	go gp.probeLoop()
 
+	gossipTicker := time.NewTicker(20 * time.Second)
 	defer gossipTicker.Stop()
-	defer heartbeatTicker.Stop()
-	defer cleanupTicker.Stop()
 
 	for {
 		select {
@@ -253,60 +338,25 @@ func (gp *GossipProtocol) StartGossipRoutine() {
 
 			if len(peerList) == 0 {
 				logEvent("GOSSIP_ROUTINE", "WARNING", "No hay peers conocidos para chismorrear.")
+				// Quizá las semillas volvieron: se intenta unirse de nuevo.
+				gp.Join()
 				continue
 			}
 
 			rand.Seed(time.Now().UnixNano())
 			targetPeer := peerList[rand.Intn(len(peerList))]
 
-			requestMsg := NetworkMessage{
-				Type:    "GET_FULL_LIST",
-				Payload: []byte{},
-			}
-			requestBytes, _ := json.Marshal(requestMsg)
-
-			conn, err := gp.connectToPeer(targetPeer)
-			if err != nil {
-				logEvent("GOSSIP_ROUTINE", "ERROR", fmt.Sprintf("Falla al conectar para chismorreo con %s: %v", targetPeer, err))
-				continue
-			}
-			
-			conn.Write(requestBytes)
-			
-			buffer := make([]byte, 4096)
-			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-			n, err := conn.Read(buffer)
-			conn.Close()
-
+			differing, err := gp.AntiEntropy(targetPeer)
 			if err != nil {
-				logEvent("GOSSIP_ROUTINE", "ERROR", fmt.Sprintf("Falla al leer respuesta de chismorreo de %s: %v", targetPeer, err))
+				logEvent("GOSSIP_ROUTINE", "ERROR", fmt.Sprintf("Falla en la anti-entropía con %s: %v", targetPeer, err))
 				continue
 			}
-
-			var responseMsg NetworkMessage
-			json.Unmarshal(buffer[:n], &responseMsg)
-
-			if responseMsg.Type == "RESPONSE_LIST" {
-				var receivedFiles map[string]DirectoryEntry
-				json.Unmarshal(responseMsg.Payload, &receivedFiles)
-
-				sharedFilesMutex.Lock()
-				for fileName, entry := range receivedFiles {
-					if existingEntry, found := sharedFiles[fileName]; !found || entry.Version > existingEntry.Version {
-						sharedFiles[fileName] = entry
-						logEvent("GOSSIP_ROUTINE", "MERGE_UPDATE", fmt.Sprintf("Actualización de chismorreo para '%s' con versión %d desde %s", fileName, entry.Version, targetPeer))
-					}
-				}
-				sharedFilesMutex.Unlock()
-
-				gp.AddPeer(targetPeer)
+			if differing > 0 {
+				logEvent("GOSSIP_ROUTINE", "SYNC", fmt.Sprintf("Directorio sincronizado con %s: %d grupos de entradas distintos.", targetPeer, differing))
 			}
 
-		case <-heartbeatTicker.C:
-			gp.SendHeartbeat()
-
-		case <-cleanupTicker.C:
-			gp.CheckDeadPeers()
+			gp.AddPeer(targetPeer)
+			gp.exchangeMembership(targetPeer)
 		}
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// testCertificate emite con la CA parent (o autofirmado si parent es nil) un
+// certificado con los SAN indicados, como los de servidor de dfs-ca.
+func testCertificate(t *testing.T, parent *tls.Certificate, serial int64, isCA bool, dnsNames []string, ips []net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "prueba"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+	issuer, signer := template, any(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestConnectToPeerChecksHost comprueba que un servidor no acepta como peer a
+// quien presenta un certificado válido de la CA pero emitido para otro host.
+func TestConnectToPeerChecksHost(t *testing.T) {
+	ca := testCertificate(t, nil, 1, true, nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	previousPool := caPool
+	caPool = roots
+	defer func() { caPool = previousPool }()
+
+	self := testCertificate(t, &ca, 2, false, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	gp, _ := NewGossipProtocol(nil, &dtls.Config{
+		Certificates:          []tls.Certificate{self},
+		RootCAs:               roots,
+		VerifyPeerCertificate: verifyPeerCertificate,
+		ExtendedMasterSecret:  dtls.RequireExtendedMasterSecret,
+	}, selfAddr)
+
+	tests := []struct {
+		name     string
+		dnsNames []string
+		ips      []net.IP
+		ok       bool
+	}{
+		{"IP del peer", nil, []net.IP{net.ParseIP("127.0.0.1")}, true},
+		{"otra IP", nil, []net.IP{net.ParseIP("10.9.9.9")}, false},
+		{"otro nombre", []string{"otro-nodo"}, nil, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peerCert := testCertificate(t, &ca, int64(10+i), false, tt.dnsNames, tt.ips)
+			listener, err := dtls.Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &dtls.Config{
+				Certificates:          []tls.Certificate{peerCert},
+				ClientAuth:            dtls.RequireAnyClientCert,
+				VerifyPeerCertificate: verifyPeerCertificate,
+				ExtendedMasterSecret:  dtls.RequireExtendedMasterSecret,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			go func() {
+				if conn, err := listener.Accept(); err == nil {
+					conn.Close()
+				}
+			}()
+
+			conn, err := gp.connectToPeerWithin(listener.Addr().String(), 3*time.Second)
+			if err == nil {
+				conn.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("connectToPeerWithin = %v; conexión esperada: %v", err, tt.ok)
+			}
+		})
+	}
+}
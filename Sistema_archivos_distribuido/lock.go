@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLockLease = 30 * time.Second
+	maxLockLease     = 5 * time.Minute
+)
+
+// LockRequest es el payload de ACQUIRE_LOCK, RENEW_LOCK y RELEASE_LOCK. Token
+// se deja vacío al adquirir y después identifica al poseedor del candado.
+type LockRequest struct {
+	FileName     string `json:"file_name"`
+	Holder       string `json:"holder,omitempty"`
+	Token        string `json:"token,omitempty"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"`
+}
+
+// LockGrant es la respuesta LOCK_GRANTED.
+type LockGrant struct {
+	FileName string    `json:"file_name"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+}
+
+// fileLease es un candado exclusivo de edición. Es un arrendamiento: si el
+// cliente deja de renovarlo (por ejemplo porque se cayó), expira solo.
+type fileLease struct {
+	Holder  string
+	Token   string
+	Expires time.Time
+}
+
+var (
+	fileLocksMutex sync.Mutex
+	fileLocks      = make(map[string]fileLease) // key: filename; sólo en el dueño.
+)
+
+// activeLease devuelve el candado vigente de fileName, descartando uno vencido.
+// Debe llamarse con fileLocksMutex tomado.
+func activeLease(fileName string) (fileLease, bool) {
+	lease, found := fileLocks[fileName]
+	if found && time.Now().After(lease.Expires) {
+		delete(fileLocks, fileName)
+		logEvent("LOCK", "LOCK_EXPIRED", fmt.Sprintf("Expiró el candado de '%s' de %s.", fileName, lease.Holder))
+		return fileLease{}, false
+	}
+	return lease, found
+}
+
+// lockConflict devuelve un mensaje de rechazo si fileName está bloqueado por un
+// poseedor distinto del token indicado. Sin candado vigente no hay conflicto.
+func lockConflict(fileName, token string) (string, bool) {
+	fileLocksMutex.Lock()
+	defer fileLocksMutex.Unlock()
+	lease, found := activeLease(fileName)
+	if !found || lease.Token == token {
+		return "", false
+	}
+	return fmt.Sprintf("'%s' está bloqueado para edición por %s durante %s más.", fileName, lease.Holder, time.Until(lease.Expires).Round(time.Second)), true
+}
+
+func leaseDuration(seconds int) time.Duration {
+	lease := time.Duration(seconds) * time.Second
+	if lease <= 0 {
+		return defaultLockLease
+	}
+	if lease > maxLockLease {
+		return maxLockLease
+	}
+	return lease
+}
+
+func newLockToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// handleLockMessage atiende ACQUIRE_LOCK, RENEW_LOCK y RELEASE_LOCK. Los
+// candados viven en el dueño del archivo; los demás nodos le reenvían la
+// petición para que el cliente pueda estar conectado a cualquier servidor.
+func handleLockMessage(msg NetworkMessage, localAddr, remoteAddr string) NetworkMessage {
+	var req LockRequest
+	json.Unmarshal(msg.Payload, &req)
+	if nack := invalidNameResponse(req.FileName, localAddr); nack != nil {
+		return *nack
+	}
+
+	sharedFilesMutex.RLock()
+	entry, found := sharedFiles[req.FileName]
+	sharedFilesMutex.RUnlock()
+	if !found || entry.Deleted {
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte("Archivo no encontrado en el directorio."),
+			Authoritative: false,
+			SenderIP:      localAddr,
+		}
+	}
+	if entry.OwnerIP != selfAddr {
+		logEvent("LOCK", "LOCK_FORWARD", fmt.Sprintf("Reenviando %s de '%s' al dueño %s.", msg.Type, req.FileName, entry.OwnerIP))
+		response, err := gossipProtocol.Forward(entry.OwnerIP, msg)
+		if err != nil {
+			logEvent("LOCK", "ERROR", err.Error())
+			return NetworkMessage{
+				Type:    "NACK",
+				Payload: []byte("No se pudo contactar al dueño del archivo para gestionar el candado."),
+			}
+		}
+		return response
+	}
+
+	if req.Holder == "" {
+		req.Holder = remoteAddr
+	}
+	fileLocksMutex.Lock()
+	defer fileLocksMutex.Unlock()
+	lease, held := activeLease(req.FileName)
+
+	switch msg.Type {
+	case "ACQUIRE_LOCK":
+		if held && lease.Token != req.Token {
+			logEvent("LOCK", "LOCK_DENIED", fmt.Sprintf("'%s' ya está bloqueado por %s; se rechaza a %s.", req.FileName, lease.Holder, req.Holder))
+			return NetworkMessage{
+				Type:          "LOCK_DENIED",
+				Payload:       []byte(fmt.Sprintf("'%s' está siendo editado por %s (el candado vence en %s).", req.FileName, lease.Holder, time.Until(lease.Expires).Round(time.Second))),
+				Authoritative: true,
+				SenderIP:      localAddr,
+			}
+		}
+		if !held {
+			lease = fileLease{Holder: req.Holder, Token: newLockToken()}
+		}
+		logEvent("LOCK", "LOCK_ACQUIRED", fmt.Sprintf("Candado de '%s' concedido a %s.", req.FileName, req.Holder))
+	case "RENEW_LOCK":
+		if !held || lease.Token != req.Token {
+			logEvent("LOCK", "LOCK_DENIED", fmt.Sprintf("Renovación rechazada para '%s': el candado ya no pertenece a %s.", req.FileName, req.Holder))
+			return NetworkMessage{
+				Type:          "LOCK_DENIED",
+				Payload:       []byte("El candado expiró o pertenece a otro cliente."),
+				Authoritative: true,
+				SenderIP:      localAddr,
+			}
+		}
+	case "RELEASE_LOCK":
+		if held && lease.Token == req.Token {
+			delete(fileLocks, req.FileName)
+			logEvent("LOCK", "LOCK_RELEASED", fmt.Sprintf("Candado de '%s' liberado por %s.", req.FileName, lease.Holder))
+		}
+		return NetworkMessage{
+			Type:          "UPDATE_ACK",
+			Payload:       []byte("Candado liberado."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	lease.Expires = time.Now().Add(leaseDuration(req.LeaseSeconds))
+	fileLocks[req.FileName] = lease
+	payloadBytes, _ := json.Marshal(LockGrant{FileName: req.FileName, Token: lease.Token, Expires: lease.Expires})
+	return NetworkMessage{
+		Type:          "LOCK_GRANTED",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
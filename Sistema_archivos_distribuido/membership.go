@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MemberInfo describe el estado de un miembro del cluster según el nodo que lo
+// envía. Es la unidad que se difunde tanto en la lista de miembros como en las
+// actualizaciones que viajan con los sondeos de fallos.
+type MemberInfo struct {
+	Addr        string `json:"addr"`
+	Incarnation uint64 `json:"incarnation"`
+	Status      string `json:"status"` // memberAlive, memberSuspect o memberDead.
+}
+
+// Members devuelve la lista de miembros conocidos, incluido este nodo y los
+// peers declarados muertos, para que la baja también se propague.
+func (gp *GossipProtocol) Members() []MemberInfo {
+	gp.mu.RLock()
+	defer gp.mu.RUnlock()
+	members := make([]MemberInfo, 0, len(gp.Peers)+len(gp.dead)+1)
+	members = append(members, gp.selfInfo())
+	for peer, state := range gp.Peers {
+		members = append(members, peerInfo(peer, state))
+	}
+	for peer, record := range gp.dead {
+		members = append(members, MemberInfo{Addr: peer, Incarnation: record.Incarnation, Status: memberDead})
+	}
+	return members
+}
+
+// MergeMembers incorpora la lista de miembros de otro nodo con las mismas
+// reglas de precedencia que las actualizaciones de SWIM.
+func (gp *GossipProtocol) MergeMembers(members []MemberInfo, source string) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	for _, member := range members {
+		gp.applyUpdate(member, source)
+	}
+}
+
+// Join se presenta ante las semillas de -peers hasta que una responde con la
+// lista de miembros. La semilla se encarga de anunciar a este nodo al resto.
+func (gp *GossipProtocol) Join() bool {
+	gp.mu.RLock()
+	payloadBytes, _ := json.Marshal(gp.selfInfo())
+	gp.mu.RUnlock()
+	for _, seed := range gp.seeds {
+		if seed == gp.selfAddr {
+			continue
+		}
+		response, err := gp.Forward(seed, NetworkMessage{Type: "JOIN", Payload: payloadBytes, SenderIP: gp.selfAddr})
+		if err != nil {
+			logEvent("MEMBERSHIP", "JOIN_FAILED", fmt.Sprintf("La semilla %s no responde: %v", seed, err))
+			continue
+		}
+		if response.Type != "MEMBERSHIP" {
+			logEvent("MEMBERSHIP", "JOIN_FAILED", fmt.Sprintf("La semilla %s respondió %s.", seed, response.Type))
+			continue
+		}
+		var members []MemberInfo
+		json.Unmarshal(response.Payload, &members)
+		gp.MergeMembers(members, seed)
+		logEvent("MEMBERSHIP", "JOINED", fmt.Sprintf("Unido al cluster a través de %s (%d miembros).", seed, len(members)))
+		return true
+	}
+	return false
+}
+
+// HandleJoin atiende JOIN: agrega al nodo nuevo, le devuelve la lista de
+// miembros y lo anuncia a los demás peers. Un nodo reiniciado vuelve con una
+// encarnación mayor, así que supera la baja que se hubiera registrado.
+func (gp *GossipProtocol) HandleJoin(msg NetworkMessage, localAddr string) NetworkMessage {
+	var newcomer MemberInfo
+	json.Unmarshal(msg.Payload, &newcomer)
+	if newcomer.Addr == "" || newcomer.Addr == gp.selfAddr {
+		return NetworkMessage{Type: "NACK", Payload: []byte("Dirección de escucha inválida.")}
+	}
+	newcomer.Status = memberAlive
+	logEvent("MEMBERSHIP", "JOIN", fmt.Sprintf("%s se une al cluster.", newcomer.Addr))
+	members := gp.Members()
+	gp.MergeMembers([]MemberInfo{newcomer}, newcomer.Addr)
+	go gp.announceMember(newcomer)
+
+	payloadBytes, _ := json.Marshal(members)
+	return NetworkMessage{
+		Type:          "MEMBERSHIP",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// announceMember comunica a todos los peers (salvo al propio nodo nuevo) que
+// newcomer se unió al cluster.
+func (gp *GossipProtocol) announceMember(newcomer MemberInfo) {
+	gp.mu.RLock()
+	payloadBytes, _ := json.Marshal([]MemberInfo{gp.selfInfo(), newcomer})
+	gp.mu.RUnlock()
+	msg := NetworkMessage{Type: "MEMBERSHIP", Payload: payloadBytes, SenderIP: gp.selfAddr}
+	for _, peer := range gp.PeerAddrs() {
+		if peer == newcomer.Addr {
+			continue
+		}
+		go func(addr string) {
+			if _, err := gp.Forward(addr, msg); err != nil {
+				logEvent("MEMBERSHIP", "ERROR", fmt.Sprintf("Falla al anunciar %s a %s: %v", newcomer.Addr, addr, err))
+			}
+		}(peer)
+	}
+}
+
+// HandleMembership atiende MEMBERSHIP: incorpora la lista recibida y responde
+// con la propia, de modo que ambos extremos terminan con la unión de las dos.
+func (gp *GossipProtocol) HandleMembership(msg NetworkMessage, localAddr string) NetworkMessage {
+	var members []MemberInfo
+	json.Unmarshal(msg.Payload, &members)
+	gp.MergeMembers(members, msg.SenderIP)
+	payloadBytes, _ := json.Marshal(gp.Members())
+	return NetworkMessage{
+		Type:          "MEMBERSHIP",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// exchangeMembership intercambia listas de miembros con peerAddr. Se hace en
+// cada ronda de chismes para que los altas y bajas se propaguen sin configurar
+// nada en los nodos.
+func (gp *GossipProtocol) exchangeMembership(peerAddr string) {
+	payloadBytes, _ := json.Marshal(gp.Members())
+	response, err := gp.Forward(peerAddr, NetworkMessage{Type: "MEMBERSHIP", Payload: payloadBytes, SenderIP: gp.selfAddr})
+	if err != nil {
+		logEvent("MEMBERSHIP", "ERROR", fmt.Sprintf("Falla al intercambiar miembros con %s: %v", peerAddr, err))
+		return
+	}
+	if response.Type != "MEMBERSHIP" {
+		return
+	}
+	var members []MemberInfo
+	json.Unmarshal(response.Payload, &members)
+	gp.MergeMembers(members, peerAddr)
+}
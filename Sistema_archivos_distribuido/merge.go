@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMergeCells limita el tamaño de la tabla LCS (líneas × líneas) para que una
+// fusión de archivos enormes no agote la memoria; por encima se usa la copia en
+// conflicto.
+const maxMergeCells = 4_000_000
+
+// MergeConflict es la respuesta UPDATE_CONFLICT: el resultado de la fusión con
+// marcadores de conflicto y el reloj de la versión actual, que el cliente debe
+// usar como base al reenviar el archivo resuelto.
+type MergeConflict struct {
+	FileName string      `json:"file_name"`
+	Content  []byte      `json:"content"`
+	Version  int         `json:"version"`
+	Clock    VectorClock `json:"clock"`
+}
+
+// isMergeableText indica si el contenido parece texto (UTF-8 sin bytes nulos).
+func isMergeableText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
+
+// splitLines separa el contenido en líneas conservando el salto de línea.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.SplitAfter(string(content), "\n")
+}
+
+// lcsMatches devuelve, para cada línea de a, el índice de la línea de b con la
+// que se empareja en la subsecuencia común más larga, o -1.
+func lcsMatches(a, b []string) []int {
+	n, m := len(a), len(b)
+	table := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) *int32 { return &table[i*(m+1)+j] }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				*at(i, j) = *at(i+1, j+1) + 1
+			} else if *at(i+1, j) >= *at(i, j+1) {
+				*at(i, j) = *at(i+1, j)
+			} else {
+				*at(i, j) = *at(i, j+1)
+			}
+		}
+	}
+
+	matches := make([]int, n)
+	for i := range matches {
+		matches[i] = -1
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i] == b[j]:
+			matches[i] = j
+			i++
+			j++
+		case *at(i+1, j) >= *at(i, j+1):
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+func sameLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeThreeWay fusiona por líneas los cambios de ours y theirs sobre base. Las
+// zonas donde sólo cambió un lado se toman de ese lado; donde cambiaron ambos de
+// forma distinta se emiten marcadores de conflicto. ok es false si el contenido
+// no es texto o es demasiado grande para fusionarlo.
+func mergeThreeWay(base, ours, theirs []byte) (merged []byte, conflict bool, ok bool) {
+	if !isMergeableText(base) || !isMergeableText(ours) || !isMergeableText(theirs) {
+		return nil, false, false
+	}
+	baseLines, ourLines, theirLines := splitLines(base), splitLines(ours), splitLines(theirs)
+	if len(baseLines)*(len(ourLines)+len(theirLines)) > maxMergeCells {
+		return nil, false, false
+	}
+	toOurs := lcsMatches(baseLines, ourLines)
+	toTheirs := lcsMatches(baseLines, theirLines)
+
+	var out strings.Builder
+	emit := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+	}
+	emitMarker := func(marker string) {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString(marker + "\n")
+	}
+
+	b, o, t := 0, 0, 0
+	for {
+		// Siguiente línea de la base que sigue igual en los dos lados.
+		stable := b
+		for stable < len(baseLines) && (toOurs[stable] < 0 || toTheirs[stable] < 0) {
+			stable++
+		}
+		oEnd, tEnd := len(ourLines), len(theirLines)
+		if stable < len(baseLines) {
+			oEnd, tEnd = toOurs[stable], toTheirs[stable]
+		}
+
+		baseChunk, ourChunk, theirChunk := baseLines[b:stable], ourLines[o:oEnd], theirLines[t:tEnd]
+		switch {
+		case sameLines(ourChunk, baseChunk):
+			emit(theirChunk)
+		case sameLines(theirChunk, baseChunk), sameLines(ourChunk, theirChunk):
+			emit(ourChunk)
+		default:
+			conflict = true
+			emitMarker("<<<<<<< versión actual del servidor")
+			emit(ourChunk)
+			emitMarker("=======")
+			emit(theirChunk)
+			emitMarker(">>>>>>> tus cambios")
+		}
+
+		if stable == len(baseLines) {
+			break
+		}
+		out.WriteString(baseLines[stable])
+		b, o, t = stable+1, oEnd+1, tEnd+1
+	}
+	return []byte(out.String()), conflict, true
+}
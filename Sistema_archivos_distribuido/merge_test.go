@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMergeThreeWay(t *testing.T) {
+	const base = "uno\ndos\ntres\ncuatro\n"
+	tests := []struct {
+		name         string
+		base         string
+		ours, theirs string
+		want         string
+		wantConflict bool
+	}{
+		{"sin cambios", base, base, base, base, false},
+		{"sólo cambia el servidor", base, "uno\nDOS\ntres\ncuatro\n", base, "uno\nDOS\ntres\ncuatro\n", false},
+		{"sólo cambia el cliente", base, base, "uno\ndos\ntres\nCUATRO\n", "uno\ndos\ntres\nCUATRO\n", false},
+		{"cambios en líneas distintas", base, "UNO\ndos\ntres\ncuatro\n", "uno\ndos\ntres\nCUATRO\n", "UNO\ndos\ntres\nCUATRO\n", false},
+		{"el mismo cambio en ambos", base, "uno\nDOS\ntres\ncuatro\n", "uno\nDOS\ntres\ncuatro\n", "uno\nDOS\ntres\ncuatro\n", false},
+		{"inserción y borrado", base, "cero\nuno\ndos\ntres\ncuatro\n", "uno\ndos\ncuatro\n", "cero\nuno\ndos\ncuatro\n", false},
+		{"desde base vacía", "", "a\n", "", "a\n", false},
+		{
+			"misma línea distinta", base, "uno\nDos\ntres\ncuatro\n", "uno\nDOS\ntres\ncuatro\n",
+			"uno\n<<<<<<< versión actual del servidor\nDos\n=======\nDOS\n>>>>>>> tus cambios\ntres\ncuatro\n", true,
+		},
+		{
+			"conflicto sin salto final", "a", "b", "c",
+			"<<<<<<< versión actual del servidor\nb\n=======\nc\n>>>>>>> tus cambios\n", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflict, ok := mergeThreeWay([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs))
+			if !ok {
+				t.Fatal("mergeThreeWay no pudo fusionar texto")
+			}
+			if string(merged) != tt.want || conflict != tt.wantConflict {
+				t.Errorf("mergeThreeWay = %q, conflicto %v; se esperaba %q, conflicto %v", merged, conflict, tt.want, tt.wantConflict)
+			}
+		})
+	}
+}
+
+func TestMergeThreeWayRejects(t *testing.T) {
+	huge := []byte(strings.Repeat("x\n", 3000))
+	tests := []struct {
+		name               string
+		base, ours, theirs []byte
+	}{
+		{"binario", []byte("a\n"), []byte{0, 1, 2}, []byte("b\n")},
+		{"UTF-8 inválido", []byte("a\n"), []byte("a\n"), []byte{0xff, 0xfe}},
+		{"demasiado grande", huge, huge, bytes.Repeat([]byte("y\n"), 3000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, ok := mergeThreeWay(tt.base, tt.ours, tt.theirs); ok {
+				t.Error("mergeThreeWay aceptó un contenido que no debía fusionar")
+			}
+		})
+	}
+}
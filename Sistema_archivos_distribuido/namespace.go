@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// El directorio del DFS es jerárquico: las claves de sharedFiles son rutas
+// lógicas separadas por "/" y cada carpeta es una entrada con IsDir. Cada ruta,
+// archivo o carpeta, tiene su propio dueño; para crear algo dentro de una
+// carpeta basta con que exista, sin importar quién sea su dueño.
+
+const (
+	defaultListDirLimit = 50
+	maxListDirLimit     = 500
+)
+
+// ListDirRequest es el payload de LIST_DIR. Cursor es el último nombre de la
+// página anterior; vacío pide la primera.
+type ListDirRequest struct {
+	Path   string `json:"path"`
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// ListDirPage es la respuesta DIR_LISTING: una página de los hijos directos de
+// Path, ordenados por nombre. NextCursor vacío indica que no hay más.
+type ListDirPage struct {
+	Path       string           `json:"path"`
+	Entries    []DirectoryEntry `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// MoveRequest es el payload de MOVE.
+type MoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// parentDir devuelve la carpeta que contiene name ("" para la raíz).
+func parentDir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// inSubtree indica si name es dir o está dentro de dir. La raíz contiene todo.
+func inSubtree(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// dirExists indica si dir es la raíz o una carpeta viva. Debe llamarse con
+// sharedFilesMutex tomado.
+func dirExists(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	entry, found := sharedFiles[dir]
+	return found && !entry.Deleted && entry.IsDir
+}
+
+// hasLiveChildren indica si una carpeta contiene entradas vivas. Debe llamarse
+// con sharedFilesMutex tomado.
+func hasLiveChildren(dir string) bool {
+	for name, entry := range sharedFiles {
+		if !entry.Deleted && name != dir && inSubtree(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtreeEntries devuelve las entradas de un subárbol (incluida la raíz del
+// subárbol). Debe llamarse con sharedFilesMutex tomado.
+func subtreeEntries(dir string) map[string]DirectoryEntry {
+	entries := make(map[string]DirectoryEntry)
+	for name, entry := range sharedFiles {
+		if inSubtree(name, dir) {
+			entries[name] = entry
+		}
+	}
+	return entries
+}
+
+// namespaceNack arma un NACK autoritativo para las operaciones de carpetas.
+func namespaceNack(localAddr, format string, args ...interface{}) NetworkMessage {
+	return NetworkMessage{
+		Type:          "NACK",
+		Payload:       []byte(fmt.Sprintf(format, args...)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleMkdir atiende MKDIR: crea una carpeta dentro de otra ya existente. La
+// carpeta nueva es de id y hereda los permisos de la que la contiene.
+func handleMkdir(msg NetworkMessage, localAddr string, id Identity) NetworkMessage {
+	var dirName string
+	json.Unmarshal(msg.Payload, &dirName)
+	logEvent("SERVER", "MKDIR_REQUEST", fmt.Sprintf("Petición para crear la carpeta '%s'.", dirName))
+	if nack := invalidNameResponse(dirName, localAddr); nack != nil {
+		return *nack
+	}
+
+	sharedFilesMutex.Lock()
+	previous, existed := sharedFiles[dirName]
+	if existed && !previous.Deleted {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "'%s' ya existe.", dirName)
+	}
+	if !dirExists(parentDir(dirName)) {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "La carpeta '%s' no existe.", parentDir(dirName))
+	}
+	if err := os.MkdirAll(localPath(dirName), 0755); err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al crear la carpeta '%s': %v", dirName, err))
+		return NetworkMessage{Type: "NACK", Payload: []byte("Error al crear la carpeta.")}
+	}
+	entry := DirectoryEntry{
+		FileName:         dirName,
+		IsDir:            true,
+		ModificationDate: time.Now(),
+		TTL:              0, // Las carpetas sólo desaparecen al borrarlas.
+		OwnerIP:          selfAddr,
+		ACL:              newEntryACL(id, parentDir(dirName)),
+	}
+	if existed {
+		entry.Clock = entryClock(previous)
+	}
+	bumpVersion(&entry, selfAddr)
+	sharedFiles[dirName] = entry
+	dirStore.Put(entry)
+	sharedFilesMutex.Unlock()
+
+	logEvent("SERVER", "DIR_CREATED", fmt.Sprintf("Carpeta '%s' creada.", dirName))
+	go gossipProtocol.GossipUpdateAllPeers(entry)
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte("Carpeta creada."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleAddFile atiende ADD_FILE: crea un archivo vacío, del que este nodo
+// queda como dueño, dentro de una carpeta ya existente. Un nombre con una
+// entrada viva se rechaza, sea de este nodo o de otro: para cambiar el
+// contenido está FILE_WRITE_UPDATE, que respeta dueño, bloqueos e historial.
+// Sobre una lápida, el archivo nuevo hereda su reloj para superarla.
+func handleAddFile(msg NetworkMessage, localAddr string, id Identity) NetworkMessage {
+	var fileName string
+	json.Unmarshal(msg.Payload, &fileName)
+	logEvent("SERVER", "ADD_FILE_REQUEST", fmt.Sprintf("Petición para agregar el archivo '%s'.", fileName))
+	if nack := invalidNameResponse(fileName, localAddr); nack != nil {
+		return *nack
+	}
+
+	sharedFilesMutex.Lock()
+	previous, existed := sharedFiles[fileName]
+	if existed && !previous.Deleted {
+		sharedFilesMutex.Unlock()
+		if previous.IsDir {
+			return namespaceNack(localAddr, "'%s' es una carpeta.", fileName)
+		}
+		return namespaceNack(localAddr, "'%s' ya existe (dueño %s).", fileName, previous.OwnerIP)
+	}
+	if !dirExists(parentDir(fileName)) {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "La carpeta '%s' no existe.", parentDir(fileName))
+	}
+	// La carpeta puede ser de otro nodo y no existir aún en este disco.
+	os.MkdirAll(filepath.Dir(localPath(fileName)), 0755)
+	file, err := os.Create(localPath(fileName))
+	if err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al crear el archivo '%s': %v", fileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al crear el archivo."),
+		}
+	}
+	file.Close()
+	newEntry := DirectoryEntry{
+		FileName:         fileName,
+		Extension:        filepath.Ext(fileName),
+		Size:             0,
+		ModificationDate: fileModTime(fileName),
+		TTL:              3600,
+		OwnerIP:          selfAddr,
+		ACL:              newEntryACL(id, parentDir(fileName)),
+	}
+	if existed {
+		newEntry.Clock = entryClock(previous)
+	}
+	bumpVersion(&newEntry, selfAddr)
+	assignReplicas(&newEntry)
+	sharedFiles[fileName] = newEntry
+	dirStore.Put(newEntry)
+	versions.Record(fileName, newEntry, nil)
+	sharedFilesMutex.Unlock()
+
+	logEvent("SERVER", "NEW_FILE_ADDED", fmt.Sprintf("Nuevo archivo '%s' agregado a la lista local.", fileName))
+	go gossipProtocol.GossipUpdateAllPeers(newEntry)
+	go replicateFile(newEntry, []byte{})
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte("Archivo agregado y compartido."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleListDir atiende LIST_DIR con una página de los hijos de una carpeta.
+func handleListDir(msg NetworkMessage, localAddr string) NetworkMessage {
+	var req ListDirRequest
+	json.Unmarshal(msg.Payload, &req)
+	if req.Path != "" {
+		if nack := invalidNameResponse(req.Path, localAddr); nack != nil {
+			return *nack
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultListDirLimit
+	}
+	if req.Limit > maxListDirLimit {
+		req.Limit = maxListDirLimit
+	}
+
+	sharedFilesMutex.RLock()
+	if !dirExists(req.Path) {
+		sharedFilesMutex.RUnlock()
+		return namespaceNack(localAddr, "La carpeta '%s' no existe.", req.Path)
+	}
+	var children []DirectoryEntry
+	for name, entry := range sharedFiles {
+		if !entry.Deleted && name != req.Path && parentDir(name) == req.Path && name > req.Cursor {
+			children = append(children, entry)
+		}
+	}
+	sharedFilesMutex.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool { return children[i].FileName < children[j].FileName })
+	page := ListDirPage{Path: req.Path, Entries: children}
+	if len(children) > req.Limit {
+		page.Entries = children[:req.Limit]
+		page.NextCursor = page.Entries[req.Limit-1].FileName
+	}
+	payloadBytes, _ := json.Marshal(page)
+	return NetworkMessage{
+		Type:          "DIR_LISTING",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleMove atiende MOVE: renombra un archivo o una carpeta con todo su
+// contenido. Lo hace el dueño del origen; para mover una carpeta, este nodo
+// debe ser dueño de todo el subárbol, porque los archivos de otros dueños no
+// están en su disco. Las rutas viejas quedan como lápidas y las nuevas se
+// anuncian con versiones nuevas.
+func handleMove(msg NetworkMessage, localAddr string) NetworkMessage {
+	var req MoveRequest
+	json.Unmarshal(msg.Payload, &req)
+	logEvent("SERVER", "MOVE_REQUEST", fmt.Sprintf("Petición para mover '%s' a '%s'.", req.From, req.To))
+	if _, response := ownedEntry(req.From, localAddr); response != nil {
+		return *response
+	}
+	if nack := invalidNameResponse(req.To, localAddr); nack != nil {
+		return *nack
+	}
+	if inSubtree(req.To, req.From) {
+		return namespaceNack(localAddr, "No se puede mover '%s' dentro de sí mismo.", req.From)
+	}
+
+	sharedFilesMutex.Lock()
+	source, found := sharedFiles[req.From]
+	if !found || source.Deleted || source.OwnerIP != selfAddr {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "'%s' cambió mientras se procesaba la petición.", req.From)
+	}
+	if target, exists := sharedFiles[req.To]; exists && !target.Deleted {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "'%s' ya existe.", req.To)
+	}
+	if !dirExists(parentDir(req.To)) {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "La carpeta '%s' no existe.", parentDir(req.To))
+	}
+	moving := map[string]DirectoryEntry{req.From: source}
+	if source.IsDir {
+		moving = subtreeEntries(req.From)
+	}
+	for name, entry := range moving {
+		if entry.Deleted {
+			delete(moving, name)
+			continue
+		}
+		if entry.OwnerIP != selfAddr {
+			sharedFilesMutex.Unlock()
+			return namespaceNack(localAddr, "'%s' pertenece a %s; sólo se pueden mover carpetas cuyo contenido sea de este servidor.", name, entry.OwnerIP)
+		}
+		if reason, locked := lockConflict(name, ""); locked {
+			sharedFilesMutex.Unlock()
+			return NetworkMessage{
+				Type:          "UPDATE_REJECTED",
+				Payload:       []byte("Movimiento rechazado: " + reason),
+				Authoritative: true,
+				SenderIP:      localAddr,
+			}
+		}
+	}
+
+	err := os.MkdirAll(filepath.Dir(localPath(req.To)), 0755)
+	if err == nil {
+		err = os.Rename(localPath(req.From), localPath(req.To))
+	}
+	if err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al mover '%s' a '%s': %v", req.From, req.To, err))
+		return NetworkMessage{Type: "NACK", Payload: []byte("Error al mover el archivo.")}
+	}
+
+	var announce, replicate []DirectoryEntry
+	for name, entry := range moving {
+		newName := req.To + strings.TrimPrefix(name, req.From)
+		moved := entry
+		moved.FileName = newName
+		moved.Extension = filepath.Ext(newName)
+		// El reloj sigue al contenido, para que el historial de versiones siga
+		// teniendo sentido, y además supera a la lápida que pudiera haber con
+		// el nombre nuevo.
+		moved.Clock = entryClock(entry).Copy()
+		if previous, existed := sharedFiles[newName]; existed {
+			for node, counter := range entryClock(previous) {
+				if counter > moved.Clock[node] {
+					moved.Clock[node] = counter
+				}
+			}
+		}
+		moved.Replicas = nil
+		bumpVersion(&moved, selfAddr)
+		if !moved.IsDir {
+			assignReplicas(&moved)
+			replicate = append(replicate, moved)
+		}
+		sharedFiles[newName] = moved
+		dirStore.Put(moved)
+		versions.Rename(name, newName)
+
+		entry.Deleted = true
+		entry.Size = 0
+		bumpVersion(&entry, selfAddr)
+		entry.ModificationDate = time.Now()
+		sharedFiles[name] = entry
+		dirStore.Put(entry)
+		announce = append(announce, moved, entry)
+	}
+	sharedFilesMutex.Unlock()
+
+	logEvent("SERVER", "MOVED", fmt.Sprintf("'%s' movido a '%s' (%d entradas).", req.From, req.To, len(moving)))
+	for _, entry := range announce {
+		go gossipProtocol.GossipUpdateAllPeers(entry)
+	}
+	for _, entry := range replicate {
+		go replicateFile(entry, nil)
+	}
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte(fmt.Sprintf("'%s' movido a '%s'.", req.From, req.To)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
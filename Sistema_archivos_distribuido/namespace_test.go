@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func addFileMessage(fileName string) NetworkMessage {
+	payloadBytes, _ := json.Marshal(fileName)
+	return NetworkMessage{Type: "ADD_FILE", Payload: payloadBytes}
+}
+
+// TestAddFileKeepsExistingEntries comprueba que ADD_FILE no pisa ni se adueña
+// de una entrada viva, propia o de otro nodo.
+func TestAddFileKeepsExistingEntries(t *testing.T) {
+	alice := Identity{Name: "alice", Groups: []string{"devs"}}
+	foreign := DirectoryEntry{FileName: "ajeno.txt", OwnerIP: "127.0.0.1:9001", Clock: VectorClock{"127.0.0.1:9001": 3}, Size: 5}
+	own := DirectoryEntry{FileName: "propio.txt", OwnerIP: selfAddr, Clock: VectorClock{selfAddr: 1}, Size: 5}
+	dir := DirectoryEntry{FileName: "docs", OwnerIP: selfAddr, IsDir: true, Clock: VectorClock{selfAddr: 1}}
+	if err := os.WriteFile(localPath(own.FileName), []byte("hola\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, existing := range []DirectoryEntry{foreign, own, dir} {
+		resetDirectory(existing)
+		response := handleAddFile(addFileMessage(existing.FileName), "127.0.0.1:9000", alice)
+		if response.Type != "NACK" {
+			t.Errorf("ADD_FILE sobre '%s' = %s %s; se esperaba NACK", existing.FileName, response.Type, response.Payload)
+		}
+		got := sharedFiles[existing.FileName]
+		if got.OwnerIP != existing.OwnerIP || got.Clock.Compare(existing.Clock) != clockEqual {
+			t.Errorf("ADD_FILE cambió '%s': dueño %s, reloj %v", existing.FileName, got.OwnerIP, got.Clock)
+		}
+	}
+	if content, _ := os.ReadFile(localPath(own.FileName)); string(content) != "hola\n" {
+		t.Errorf("ADD_FILE vació un archivo existente: %q", content)
+	}
+}
+
+// TestAddFileOverTombstone comprueba que un archivo creado con el nombre de uno
+// borrado supera a la lápida.
+func TestAddFileOverTombstone(t *testing.T) {
+	tombstone := DirectoryEntry{FileName: "borrado.txt", OwnerIP: "127.0.0.1:9001", Deleted: true, Clock: VectorClock{"127.0.0.1:9001": 4}}
+	resetDirectory(tombstone)
+	response := handleAddFile(addFileMessage("borrado.txt"), "127.0.0.1:9000", Identity{Name: "alice"})
+	if response.Type != "UPDATE_ACK" {
+		t.Fatalf("ADD_FILE sobre una lápida = %s %s", response.Type, response.Payload)
+	}
+	got := sharedFiles["borrado.txt"]
+	if got.Deleted || got.OwnerIP != selfAddr || got.Clock.Compare(tombstone.Clock) != clockAfter {
+		t.Errorf("entrada nueva = %+v; debe ser viva, de este nodo y posterior a la lápida", got)
+	}
+	if got.ACL == nil || got.ACL.Owner != "alice" {
+		t.Errorf("ACL = %+v; se esperaba una nueva de alice", got.ACL)
+	}
+}
+
+// TestAddFileRespectsLocks comprueba que ADD_FILE no vacía un archivo que otro
+// cliente tiene bloqueado.
+func TestAddFileRespectsLocks(t *testing.T) {
+	entry := DirectoryEntry{FileName: "bloqueado.txt", OwnerIP: selfAddr, Clock: VectorClock{selfAddr: 1}, Size: 9}
+	resetDirectory(entry)
+	if err := os.WriteFile(localPath(entry.FileName), []byte("en curso\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileLocksMutex.Lock()
+	fileLocks[entry.FileName] = fileLease{Holder: "bob", Token: "t1", Expires: time.Now().Add(time.Minute)}
+	fileLocksMutex.Unlock()
+	defer func() {
+		fileLocksMutex.Lock()
+		delete(fileLocks, entry.FileName)
+		fileLocksMutex.Unlock()
+	}()
+
+	if response := handleAddFile(addFileMessage(entry.FileName), "127.0.0.1:9000", Identity{Name: "alice"}); response.Type != "NACK" {
+		t.Errorf("ADD_FILE sobre un archivo bloqueado = %s %s; se esperaba NACK", response.Type, response.Payload)
+	}
+	if content, _ := os.ReadFile(localPath(entry.FileName)); string(content) != "en curso\n" {
+		t.Errorf("ADD_FILE vació un archivo bloqueado: %q", content)
+	}
+}
+
+// TestAddFileKeepsHistory comprueba que ADD_FILE sobre un archivo existente no
+// pierde su contenido ni su historial de versiones.
+func TestAddFileKeepsHistory(t *testing.T) {
+	entry := DirectoryEntry{FileName: "historial.txt", OwnerIP: selfAddr, Clock: VectorClock{selfAddr: 2}, Size: 3}
+	resetDirectory(entry)
+	if err := os.WriteFile(localPath(entry.FileName), []byte("v2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recordCurrentVersion(entry.FileName, entry)
+	before := versions.History(entry.FileName)
+
+	if response := handleAddFile(addFileMessage(entry.FileName), "127.0.0.1:9000", Identity{Name: "alice"}); response.Type != "NACK" {
+		t.Errorf("ADD_FILE sobre un archivo existente = %s %s; se esperaba NACK", response.Type, response.Payload)
+	}
+	after := versions.History(entry.FileName)
+	if len(before) == 0 || len(after) != len(before) || after[len(after)-1].Hash != before[len(before)-1].Hash {
+		t.Errorf("historial antes %v, después %v", before, after)
+	}
+	if content, ok := versions.Lookup(entry.FileName, entry.Clock); !ok || string(content) != "v2\n" {
+		t.Errorf("versión guardada = %q, %v", content, ok)
+	}
+	if content, _ := os.ReadFile(localPath(entry.FileName)); string(content) != "v2\n" {
+		t.Errorf("contenido = %q", content)
+	}
+}
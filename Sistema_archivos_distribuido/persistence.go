@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// walRecord es una mutación del directorio registrada en el write-ahead log.
+type walRecord struct {
+	Op        string          `json:"op"` // PUT o DELETE
+	FileName  string          `json:"file_name"`
+	Entry     *DirectoryEntry `json:"entry,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// directoryStore persiste sharedFiles en disco: un snapshot completo más un WAL
+// con las mutaciones posteriores. Al arrancar se carga el snapshot y se
+// reaplican las entradas del WAL.
+type directoryStore struct {
+	mu           sync.Mutex
+	walPath      string
+	snapshotPath string
+	wal          *os.File
+}
+
+var dirStore *directoryStore
+
+// openDirectoryStore prepara los archivos de estado con el prefijo indicado.
+func openDirectoryStore(prefix string) *directoryStore {
+	return &directoryStore{
+		walPath:      prefix + ".wal",
+		snapshotPath: prefix + ".snapshot.json",
+	}
+}
+
+// Replay reconstruye el directorio a partir del snapshot y el WAL, y deja el WAL
+// abierto para seguir agregando mutaciones.
+func (ds *directoryStore) Replay() (map[string]DirectoryEntry, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	files := make(map[string]DirectoryEntry)
+	if data, err := os.ReadFile(ds.snapshotPath); err == nil {
+		if err := json.Unmarshal(data, &files); err != nil {
+			return nil, fmt.Errorf("snapshot '%s' corrupto: %v", ds.snapshotPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("falla al leer el snapshot: %v", err)
+	}
+
+	if walFile, err := os.Open(ds.walPath); err == nil {
+		scanner := bufio.NewScanner(walFile)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		applied := 0
+		for scanner.Scan() {
+			var rec walRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				// Normalmente es la última línea escrita a medias antes de una caída.
+				logEvent("PERSISTENCE", "WAL_SKIP", fmt.Sprintf("Registro ilegible en el WAL ignorado: %v", err))
+				continue
+			}
+			switch rec.Op {
+			case "PUT":
+				if rec.Entry != nil {
+					files[rec.FileName] = *rec.Entry
+				}
+			case "DELETE":
+				delete(files, rec.FileName)
+			}
+			applied++
+		}
+		walFile.Close()
+		logEvent("PERSISTENCE", "WAL_REPLAY", fmt.Sprintf("Reaplicadas %d mutaciones desde '%s'.", applied, ds.walPath))
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("falla al leer el WAL: %v", err)
+	}
+
+	wal, err := os.OpenFile(ds.walPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("falla al abrir el WAL: %v", err)
+	}
+	ds.wal = wal
+	return files, nil
+}
+
+// append escribe y sincroniza un registro en el WAL.
+func (ds *directoryStore) append(rec walRecord) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.wal == nil {
+		return
+	}
+	rec.Timestamp = time.Now()
+	recBytes, err := json.Marshal(rec)
+	if err != nil {
+		logEvent("PERSISTENCE", "ERROR", fmt.Sprintf("Falla al serializar registro del WAL: %v", err))
+		return
+	}
+	recBytes = append(recBytes, '\n')
+	if _, err := ds.wal.Write(recBytes); err != nil {
+		logEvent("PERSISTENCE", "ERROR", fmt.Sprintf("Falla al escribir en el WAL: %v", err))
+		return
+	}
+	if err := ds.wal.Sync(); err != nil {
+		logEvent("PERSISTENCE", "ERROR", fmt.Sprintf("Falla al sincronizar el WAL: %v", err))
+	}
+}
+
+// Put registra el alta o modificación de una entrada. Debe llamarse con
+// sharedFilesMutex tomado para que el orden del WAL coincida con el del mapa.
+func (ds *directoryStore) Put(entry DirectoryEntry) {
+	ds.append(walRecord{Op: "PUT", FileName: entry.FileName, Entry: &entry})
+}
+
+// Delete registra la eliminación de una entrada. Igual que Put, se llama con
+// sharedFilesMutex tomado.
+func (ds *directoryStore) Delete(fileName string) {
+	ds.append(walRecord{Op: "DELETE", FileName: fileName})
+}
+
+// Compact escribe un snapshot del directorio actual y vacía el WAL. Mantiene
+// sharedFilesMutex en lectura para que ninguna mutación quede entre el snapshot
+// y el truncado.
+func (ds *directoryStore) Compact() error {
+	sharedFilesMutex.RLock()
+	defer sharedFilesMutex.RUnlock()
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	data, err := json.Marshal(sharedFiles)
+	if err != nil {
+		return fmt.Errorf("falla al serializar el snapshot: %v", err)
+	}
+	tmpPath := ds.snapshotPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("falla al crear el snapshot: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falla al escribir el snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("falla al sincronizar el snapshot: %v", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmpPath, ds.snapshotPath); err != nil {
+		return fmt.Errorf("falla al reemplazar el snapshot: %v", err)
+	}
+	if ds.wal != nil {
+		if err := ds.wal.Truncate(0); err != nil {
+			return fmt.Errorf("falla al truncar el WAL: %v", err)
+		}
+	}
+	return nil
+}
+
+// compactLoop compacta el WAL periódicamente.
+func (ds *directoryStore) compactLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ds.Compact(); err != nil {
+			logEvent("PERSISTENCE", "ERROR", fmt.Sprintf("Falla al compactar el estado: %v", err))
+			continue
+		}
+		logEvent("PERSISTENCE", "COMPACT", fmt.Sprintf("Snapshot escrito en '%s' y WAL reiniciado.", ds.snapshotPath))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// readPolicy decide qué hace un servidor cuando le piden un archivo ajeno del
+// que no guarda réplica: "proxy" lo obtiene del dueño y lo sirve (política de
+// repositorio); "redirect" responde REDIRECT_OWNER para que el cliente vaya al dueño.
+var readPolicy = "proxy"
+
+// proxyCache guarda las copias obtenidas de los dueños, una por archivo y
+// asociada al reloj de la versión, con el mismo formato que las réplicas.
+var proxyCache *replicaStore
+
+// proxyFetch obtiene del dueño la versión actual de entry, la deja en
+// proxyCache y registra la unidad de trabajo para que una edición posterior de
+// esta copia se reenvíe al dueño.
+func proxyFetch(entry DirectoryEntry) (string, bool) {
+	if path, valid := proxyCache.Path(entry); valid {
+		recordWorkUnit(entry)
+		return path, true
+	}
+
+	// El dueño puede tener una versión más nueva que la que conoce este nodo:
+	// se toma su entrada para que la copia quede asociada al reloj correcto.
+	if ownerEntry, err := gossipProtocol.RequestStatus(entry.FileName, entry.OwnerIP); err == nil {
+		sharedFilesMutex.Lock()
+		mergeEntry(*ownerEntry)
+		sharedFilesMutex.Unlock()
+		entry = *ownerEntry
+	}
+
+	payloadBytes, _ := json.Marshal(entry.FileName)
+	response, err := gossipProtocol.Forward(entry.OwnerIP, NetworkMessage{Type: "REQUEST_FILE", Payload: payloadBytes, SenderIP: selfAddr})
+	if err != nil {
+		logEvent("PROXY", "FETCH_FAILED", fmt.Sprintf("No se pudo obtener '%s' de su dueño %s: %v", entry.FileName, entry.OwnerIP, err))
+		return "", false
+	}
+	if response.Type != "FILE_RESPONSE" {
+		logEvent("PROXY", "FETCH_FAILED", fmt.Sprintf("El dueño %s respondió %s para '%s': %s", entry.OwnerIP, response.Type, entry.FileName, string(response.Payload)))
+		return "", false
+	}
+	if err := proxyCache.Store(entry, response.Payload); err != nil {
+		logEvent("PROXY", "ERROR", fmt.Sprintf("Falla al guardar la copia de '%s': %v", entry.FileName, err))
+		return "", false
+	}
+	logEvent("PROXY", "FETCHED", fmt.Sprintf("'%s' (versión %d, %d bytes) obtenido de %s.", entry.FileName, entry.Version, len(response.Payload), entry.OwnerIP))
+	recordWorkUnit(entry)
+	return proxyCache.Path(entry)
+}
+
+// recordWorkUnit anota de qué dueño proviene la copia local de un archivo.
+func recordWorkUnit(entry DirectoryEntry) {
+	localWorkUnitsMutex.Lock()
+	localWorkUnits[entry.FileName] = entry.OwnerIP
+	localWorkUnitsMutex.Unlock()
+}
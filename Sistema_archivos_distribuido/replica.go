@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// replicationFactor es el número de peers que guardan una copia de cada
+// archivo propio, además del dueño.
+var replicationFactor = 2
+
+// ReplicaPush es el payload de REPLICA_PUSH: el dueño envía el contenido de una
+// versión a un peer que figura en Replicas.
+type ReplicaPush struct {
+	Entry   DirectoryEntry `json:"entry"`
+	Content []byte         `json:"content"`
+}
+
+// replicaRecord describe la copia local de un archivo ajeno.
+type replicaRecord struct {
+	Clock VectorClock `json:"clock"`
+	Hash  string      `json:"hash"`
+	Size  int64       `json:"size"`
+}
+
+// replicaStore guarda en disco las réplicas de archivos de otros dueños, una
+// por archivo (la versión más reciente recibida).
+type replicaStore struct {
+	mu      sync.Mutex
+	dir     string
+	records map[string]replicaRecord
+}
+
+var replicas *replicaStore
+
+// openReplicaStore crea (si hace falta) el directorio de réplicas y carga el índice.
+func openReplicaStore(dir string) (*replicaStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("falla al crear el directorio de réplicas '%s': %v", dir, err)
+	}
+	rs := &replicaStore{dir: dir, records: make(map[string]replicaRecord)}
+	data, err := os.ReadFile(rs.indexPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &rs.records); err != nil {
+			return nil, fmt.Errorf("índice de réplicas corrupto: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("falla al leer el índice de réplicas: %v", err)
+	}
+	return rs, nil
+}
+
+func (rs *replicaStore) indexPath() string {
+	return filepath.Join(rs.dir, "replicas.json")
+}
+
+func (rs *replicaStore) objectPath(hash string) string {
+	return filepath.Join(rs.dir, hash)
+}
+
+// saveIndex reescribe el índice de forma atómica. Se llama con rs.mu tomado.
+func (rs *replicaStore) saveIndex() {
+	data, _ := json.Marshal(rs.records)
+	tmpPath := rs.indexPath() + ".tmp"
+	err := os.WriteFile(tmpPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, rs.indexPath())
+	}
+	if err != nil {
+		logEvent("REPLICA", "ERROR", fmt.Sprintf("Falla al guardar el índice de réplicas: %v", err))
+	}
+}
+
+// removeIfUnreferenced borra un objeto que ya no usa ninguna réplica. Se llama
+// con rs.mu tomado.
+func (rs *replicaStore) removeIfUnreferenced(hash string) {
+	for _, rec := range rs.records {
+		if rec.Hash == hash {
+			return
+		}
+	}
+	os.Remove(rs.objectPath(hash))
+}
+
+// Store guarda el contenido de entry si es más reciente que la réplica actual.
+func (rs *replicaStore) Store(entry DirectoryEntry, content []byte) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	clock := entryClock(entry)
+	previous, found := rs.records[entry.FileName]
+	if found {
+		if order := clock.Compare(previous.Clock); order == clockBefore || order == clockEqual {
+			return nil
+		}
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(rs.objectPath(hash), content, 0644); err != nil {
+		return err
+	}
+	rs.records[entry.FileName] = replicaRecord{Clock: clock.Copy(), Hash: hash, Size: int64(len(content))}
+	if found && previous.Hash != hash {
+		rs.removeIfUnreferenced(previous.Hash)
+	}
+	rs.saveIndex()
+	return nil
+}
+
+// Path devuelve la ruta de la réplica local de entry si corresponde exactamente
+// a la versión que anuncia el directorio.
+func (rs *replicaStore) Path(entry DirectoryEntry) (string, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rec, found := rs.records[entry.FileName]
+	if !found || rec.Clock.Compare(entryClock(entry)) != clockEqual {
+		return "", false
+	}
+	return rs.objectPath(rec.Hash), true
+}
+
+// Forget elimina la réplica de un archivo.
+func (rs *replicaStore) Forget(fileName string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rec, found := rs.records[fileName]
+	if !found {
+		return
+	}
+	delete(rs.records, fileName)
+	rs.removeIfUnreferenced(rec.Hash)
+	rs.saveIndex()
+}
+
+// servingPath devuelve desde dónde puede este nodo servir el contenido de
+// entry: el archivo propio, una réplica válida de la versión actual o, con la
+// política de lectura "proxy", una copia obtenida del dueño. allowProxy es
+// false cuando la petición ya viene reenviada por otro servidor, para no
+// encadenar proxies entre nodos con directorios desactualizados.
+func servingPath(entry DirectoryEntry, allowProxy bool) (string, bool) {
+	if entry.Deleted || entry.IsDir || entry.OwnerIP == "" {
+		return "", false
+	}
+	if entry.OwnerIP == selfAddr {
+		return localPath(entry.FileName), true
+	}
+	if path, valid := replicas.Path(entry); valid {
+		return path, true
+	}
+	if !allowProxy || readPolicy != "proxy" {
+		return "", false
+	}
+	return proxyFetch(entry)
+}
+
+// assignReplicas elige los peers que guardarán copia de una versión nueva de
+// un archivo propio. Conserva los que ya la tenían, para no mover contenido sin
+// necesidad, y completa con peers al azar. Debe llamarse antes de publicar la
+// entrada, para que la lista viaje con ella.
+func assignReplicas(entry *DirectoryEntry) {
+	if replicationFactor <= 0 {
+		entry.Replicas = nil
+		return
+	}
+	peers := gossipProtocol.PeerAddrs()
+	live := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		live[peer] = true
+	}
+	var chosen []string
+	for _, holder := range entry.Replicas {
+		if live[holder] && len(chosen) < replicationFactor {
+			chosen = append(chosen, holder)
+			delete(live, holder)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, peer := range peers {
+		if len(chosen) >= replicationFactor {
+			break
+		}
+		if live[peer] {
+			chosen = append(chosen, peer)
+		}
+	}
+	entry.Replicas = chosen
+}
+
+// replicateFile envía el contenido de una versión propia a sus réplicas. Si
+// content es nil se lee del disco.
+func replicateFile(entry DirectoryEntry, content []byte) {
+	if len(entry.Replicas) == 0 {
+		return
+	}
+	if content == nil {
+		var err error
+		content, err = os.ReadFile(localPath(entry.FileName))
+		if err != nil {
+			logEvent("REPLICA", "ERROR", fmt.Sprintf("Falla al leer '%s' para replicarlo: %v", entry.FileName, err))
+			return
+		}
+	}
+	payloadBytes, _ := json.Marshal(ReplicaPush{Entry: entry, Content: content})
+	msg := NetworkMessage{Type: "REPLICA_PUSH", Payload: payloadBytes, SenderIP: selfAddr}
+	for _, holder := range entry.Replicas {
+		go func(addr string) {
+			response, err := gossipProtocol.Forward(addr, msg)
+			if err != nil || response.Type != "UPDATE_ACK" {
+				logEvent("REPLICA", "PUSH_FAILED", fmt.Sprintf("No se pudo replicar '%s' (versión %d) en %s: %v", entry.FileName, entry.Version, addr, err))
+				return
+			}
+			logEvent("REPLICA", "PUSH_OK", fmt.Sprintf("'%s' (versión %d) replicado en %s.", entry.FileName, entry.Version, addr))
+		}(holder)
+	}
+}
+
+// handleReplicaPush atiende REPLICA_PUSH: guarda la réplica y aplica la
+// entrada al directorio como lo haría un GOSSIP_UPDATE.
+func handleReplicaPush(msg NetworkMessage, localAddr string) NetworkMessage {
+	var push ReplicaPush
+	if err := json.Unmarshal(msg.Payload, &push); err != nil {
+		return NetworkMessage{Type: "NACK", Payload: []byte("Réplica inválida.")}
+	}
+	if err := replicas.Store(push.Entry, push.Content); err != nil {
+		logEvent("REPLICA", "ERROR", fmt.Sprintf("Falla al guardar la réplica de '%s': %v", push.Entry.FileName, err))
+		return NetworkMessage{Type: "NACK", Payload: []byte("Error al guardar la réplica.")}
+	}
+	sharedFilesMutex.Lock()
+	mergeEntry(push.Entry)
+	sharedFilesMutex.Unlock()
+	logEvent("REPLICA", "REPLICA_STORED", fmt.Sprintf("Réplica de '%s' (versión %d) recibida de %s.", push.Entry.FileName, push.Entry.Version, push.Entry.OwnerIP))
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte("Réplica guardada."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revocación de certificados. La lista de revocados es un archivo de texto con
+// un número de serie (decimal) por línea, que dfs-ca revoke va ampliando; lo
+// que sigue a un "#" es comentario. Cada servidor la vuelve a leer cuando
+// cambia y cierra las sesiones abiertas con certificados revocados; los
+// handshakes nuevos se rechazan en verifyPeerCertificate. Es una lista local y
+// no una CRL firmada para seguir aceptando las CA antiguas, que no tienen el
+// uso de firma de CRL.
+var (
+	revocationMutex sync.RWMutex
+	revokedSerials  = make(map[string]bool) // key: número de serie en decimal.
+	revokedModTime  time.Time
+
+	sessionsMutex sync.Mutex
+	sessions      = make(map[net.Conn]string) // Sesiones entrantes y el número de serie de su certificado.
+)
+
+// loadRevocationList lee la lista de revocados si cambió desde la última
+// carga. Sin archivo no hay certificados revocados. Devuelve true si la lista
+// cambió.
+func loadRevocationList(listPath string) (bool, error) {
+	info, err := os.Stat(listPath)
+	if os.IsNotExist(err) {
+		revocationMutex.Lock()
+		defer revocationMutex.Unlock()
+		changed := len(revokedSerials) > 0
+		revokedSerials = make(map[string]bool)
+		revokedModTime = time.Time{}
+		return changed, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	revocationMutex.RLock()
+	unchanged := info.ModTime().Equal(revokedModTime)
+	revocationMutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		return false, err
+	}
+	serials := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(line, 10)
+		if !ok {
+			return false, fmt.Errorf("línea %d de '%s': número de serie inválido '%s'", i+1, listPath, line)
+		}
+		serials[serial.String()] = true
+	}
+
+	revocationMutex.Lock()
+	revokedSerials = serials
+	revokedModTime = info.ModTime()
+	revocationMutex.Unlock()
+	logEvent("CERT", "REVOCATION_LIST_LOADED", fmt.Sprintf("Lista de revocados '%s' cargada: %d certificados revocados.", listPath, len(serials)))
+	return true, nil
+}
+
+// isRevoked indica si el certificado con ese número de serie está revocado.
+func isRevoked(serial string) bool {
+	revocationMutex.RLock()
+	defer revocationMutex.RUnlock()
+	return revokedSerials[serial]
+}
+
+// verifyPeerCertificate valida el certificado del otro extremo, en los
+// handshakes entrantes y en los que este servidor inicia con sus peers: debe
+// estar firmado por la CA y no estar revocado. La cadena se verifica aquí y no
+// con RequireAndVerifyClientCert para seguir aceptando como peers a servidores
+// con certificados anteriores a dfs-ca, como el server.crt del repositorio,
+// que sólo llevan el uso de servidor; los que emite dfs-ca llevan ambos.
+func verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no se presentó certificado")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("certificado malformado: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, raw := range rawCerts[1:] {
+		if intermediate, err := x509.ParseCertificate(raw); err == nil {
+			intermediates.AddCert(intermediate)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         caPool,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		logEvent("CERT", "CERT_REJECTED", fmt.Sprintf("Certificado de '%s' rechazado: %v", cert.Subject.CommonName, err))
+		return err
+	}
+	if isRevoked(cert.SerialNumber.String()) {
+		logEvent("CERT", "CERT_REVOKED", fmt.Sprintf("Certificado revocado de '%s' (serie %s) rechazado.", cert.Subject.CommonName, cert.SerialNumber))
+		return fmt.Errorf("el certificado de '%s' está revocado", cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// verifyPeerHost comprueba, al conectarse a otro servidor, que su certificado
+// fue emitido para host (un nombre DNS o una IP de sus SAN).
+func verifyPeerHost(rawCerts [][]byte, host string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no se presentó certificado")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("certificado malformado: %v", err)
+	}
+	if err := cert.VerifyHostname(host); err != nil {
+		logEvent("CERT", "CERT_REJECTED", fmt.Sprintf("Certificado de '%s' rechazado para %s: %v", cert.Subject.CommonName, host, err))
+		return err
+	}
+	return nil
+}
+
+// registerSession recuerda una sesión entrante para poder cerrarla si su
+// certificado se revoca.
+func registerSession(conn net.Conn, serial string) {
+	sessionsMutex.Lock()
+	sessions[conn] = serial
+	sessionsMutex.Unlock()
+}
+
+func unregisterSession(conn net.Conn) {
+	sessionsMutex.Lock()
+	delete(sessions, conn)
+	sessionsMutex.Unlock()
+}
+
+// dropRevokedSessions cierra las sesiones abiertas con certificados revocados.
+func dropRevokedSessions() {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for conn, serial := range sessions {
+		if isRevoked(serial) {
+			logEvent("CERT", "SESSION_DROPPED", fmt.Sprintf("Cerrando la sesión de %s: su certificado (serie %s) fue revocado.", conn.RemoteAddr(), serial))
+			conn.Close()
+			delete(sessions, conn)
+		}
+	}
+}
+
+// watchRevocationList recarga la lista de revocados cada interval y, si
+// cambió, cierra las sesiones afectadas.
+func watchRevocationList(listPath string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := loadRevocationList(listPath)
+		if err != nil {
+			// Se conserva la última lista válida.
+			logEvent("CERT", "ERROR", fmt.Sprintf("Falla al recargar la lista de revocados: %v", err))
+			continue
+		}
+		if changed {
+			dropRevokedSessions()
+		}
+	}
+}
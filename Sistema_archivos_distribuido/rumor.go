@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Difusión de actualizaciones por rumores (modelo epidémico). Quien origina un
+// cambio lo envía a rumorFanout peers al azar; cada peer que oye el rumor por
+// primera vez lo aplica y lo reenvía a otros rumorFanout, hasta agotar las
+// rondas. Los rumores repetidos se descartan por su ID. Es la parte "push": la
+// anti-entropía periódica (antientropy.go) es la parte "pull" que recoge lo que
+// un rumor no haya alcanzado.
+var (
+	rumorFanout = 3
+	rumorRounds = 0 // 0: se calcula a partir del tamaño del cluster.
+)
+
+// rumorSeenTTL es cuánto se recuerda un ID de rumor para descartar repetidos.
+const rumorSeenTTL = 10 * time.Minute
+
+// Rumor es el payload de RUMOR.
+type Rumor struct {
+	ID     string         `json:"id"`
+	Kind   string         `json:"kind"` // GOSSIP_UPDATE o FILE_COPY_UPDATE.
+	Entry  DirectoryEntry `json:"entry"`
+	Hops   int            `json:"hops"`   // Saltos recorridos desde el origen.
+	Origin string         `json:"origin"` // Nodo que originó el cambio.
+	Born   int64          `json:"born"`   // Hora de origen en milisegundos Unix.
+}
+
+// GossipMetrics resume el tráfico y la velocidad de la difusión de rumores. La
+// latencia compara la hora de origen con la de llegada, así que sólo es fiable
+// con los relojes de los nodos sincronizados.
+type GossipMetrics struct {
+	Fanout         int     `json:"fanout"`
+	Rounds         int     `json:"rounds"`
+	Originated     int64   `json:"originated"`      // Rumores creados por este nodo.
+	Received       int64   `json:"received"`        // Rumores nuevos recibidos.
+	Duplicates     int64   `json:"duplicates"`      // Rumores repetidos descartados.
+	MessagesSent   int64   `json:"messages_sent"`   // Envíos de rumores (propios y reenviados).
+	BytesSent      int64   `json:"bytes_sent"`      // Bytes de payload enviados en rumores.
+	SendFailures   int64   `json:"send_failures"`   // Envíos que no obtuvieron respuesta.
+	AvgHops        float64 `json:"avg_hops"`        // Saltos medios de los rumores recibidos.
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`  // Tiempo medio desde el origen hasta este nodo.
+	MaxLatencyMs   int64   `json:"max_latency_ms"`  // Peor tiempo de llegada observado.
+	DuplicateRatio float64 `json:"duplicate_ratio"` // Repetidos / recibidos en total.
+}
+
+// rumorState guarda los IDs vistos y los contadores de las métricas.
+type rumorState struct {
+	mu             sync.Mutex
+	seen           map[string]time.Time
+	lastPurge      time.Time
+	metrics        GossipMetrics
+	totalHops      int64
+	totalLatencyMs int64
+}
+
+var rumors = &rumorState{seen: make(map[string]time.Time)}
+
+// markSeen registra un ID y devuelve false si ya se había visto.
+func (rs *rumorState) markSeen(id string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	if now.Sub(rs.lastPurge) > time.Minute {
+		for seenID, at := range rs.seen {
+			if now.Sub(at) > rumorSeenTTL {
+				delete(rs.seen, seenID)
+			}
+		}
+		rs.lastPurge = now
+	}
+	if _, seen := rs.seen[id]; seen {
+		rs.metrics.Duplicates++
+		return false
+	}
+	rs.seen[id] = now
+	return true
+}
+
+// newRumorID genera un identificador aleatorio de rumor.
+func newRumorID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// rounds devuelve el número máximo de saltos de un rumor. Por defecto basta con
+// log_fanout(N) rondas para alcanzar a todos con alta probabilidad; se suman
+// dos de margen para los envíos que fallan o caen en nodos que ya lo tenían.
+func (gp *GossipProtocol) rounds() int {
+	if rumorRounds > 0 {
+		return rumorRounds
+	}
+	clusterSize := float64(len(gp.PeerAddrs()) + 1)
+	fanout := math.Max(float64(rumorFanout), 2)
+	return int(math.Ceil(math.Log(clusterSize)/math.Log(fanout))) + 2
+}
+
+// SpreadRumor origina un rumor con una entrada del directorio.
+func (gp *GossipProtocol) SpreadRumor(kind string, entry DirectoryEntry) {
+	rumor := Rumor{
+		ID:     newRumorID(),
+		Kind:   kind,
+		Entry:  entry,
+		Origin: gp.selfAddr,
+		Born:   time.Now().UnixMilli(),
+	}
+	rumors.markSeen(rumor.ID)
+	rumors.mu.Lock()
+	rumors.metrics.Originated++
+	rumors.mu.Unlock()
+	gp.pushRumor(rumor, "")
+}
+
+// pushRumor envía un rumor a rumorFanout peers al azar, sin contar a quien lo
+// mandó ni a su origen.
+func (gp *GossipProtocol) pushRumor(rumor Rumor, from string) {
+	var targets []string
+	for _, peer := range gp.GetRandomPeers(rumorFanout + 2) {
+		if peer != from && peer != rumor.Origin && len(targets) < rumorFanout {
+			targets = append(targets, peer)
+		}
+	}
+	payloadBytes, _ := json.Marshal(rumor)
+	msg := NetworkMessage{Type: "RUMOR", Payload: payloadBytes, SenderIP: gp.selfAddr}
+	for _, peerAddr := range targets {
+		go func(addr string) {
+			_, err := gp.Forward(addr, msg)
+			rumors.mu.Lock()
+			rumors.metrics.MessagesSent++
+			rumors.metrics.BytesSent += int64(len(payloadBytes))
+			if err != nil {
+				rumors.metrics.SendFailures++
+			}
+			rumors.mu.Unlock()
+			if err != nil {
+				logEvent("GOSSIP", "ERROR", fmt.Sprintf("Falla al enviar el rumor de '%s' a %s: %v", rumor.Entry.FileName, addr, err))
+				return
+			}
+			logEvent("GOSSIP", "SEND_UPDATE", fmt.Sprintf("Enviando %s para '%s' a %s (salto %d)", rumor.Kind, rumor.Entry.FileName, addr, rumor.Hops+1))
+		}(peerAddr)
+	}
+}
+
+// handleRumor atiende RUMOR: aplica la entrada la primera vez que se oye el
+// rumor y lo reenvía mientras le queden rondas.
+func handleRumor(msg NetworkMessage, localAddr string) NetworkMessage {
+	response := NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte("Rumor recibido."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+	var rumor Rumor
+	if err := json.Unmarshal(msg.Payload, &rumor); err != nil || rumor.ID == "" {
+		return NetworkMessage{Type: "NACK", Payload: []byte("Rumor inválido.")}
+	}
+	if !rumors.markSeen(rumor.ID) {
+		return response
+	}
+	rumor.Hops++
+	latency := time.Now().UnixMilli() - rumor.Born
+	rumors.mu.Lock()
+	rumors.metrics.Received++
+	rumors.totalHops += int64(rumor.Hops)
+	rumors.totalLatencyMs += latency
+	if latency > rumors.metrics.MaxLatencyMs {
+		rumors.metrics.MaxLatencyMs = latency
+	}
+	rumors.mu.Unlock()
+
+	switch rumor.Kind {
+	case "FILE_COPY_UPDATE":
+		applyOwnershipUpdate(rumor.Entry)
+	default:
+		applyGossipUpdate(rumor.Entry)
+	}
+
+	if rumor.Hops < gossipProtocol.rounds() {
+		go gossipProtocol.pushRumor(rumor, msg.SenderIP)
+	}
+	return response
+}
+
+// Metrics devuelve una copia de las métricas de difusión.
+func (rs *rumorState) Metrics() GossipMetrics {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	metrics := rs.metrics
+	metrics.Fanout = rumorFanout
+	metrics.Rounds = gossipProtocol.rounds()
+	if metrics.Received > 0 {
+		metrics.AvgHops = float64(rs.totalHops) / float64(metrics.Received)
+		metrics.AvgLatencyMs = float64(rs.totalLatencyMs) / float64(metrics.Received)
+	}
+	if total := metrics.Received + metrics.Duplicates; total > 0 {
+		metrics.DuplicateRatio = float64(metrics.Duplicates) / float64(total)
+	}
+	return metrics
+}
+
+// handleGetMetrics atiende GET_METRICS con las métricas de difusión.
+func handleGetMetrics(localAddr string) NetworkMessage {
+	payloadBytes, _ := json.Marshal(rumors.Metrics())
+	return NetworkMessage{
+		Type:          "METRICS",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -14,30 +15,40 @@ import (
 	"time"
 
 	"github.com/pion/dtls/v2"
-	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
 )
 
 type DirectoryEntry struct {
-	FileName         string    `json:"file_name"`
-	Size             int64     `json:"size"`
-	ModificationDate time.Time `json:"modification_date"`
-	Version          int       `json:"version"`
-	TTL              int       `json:"ttl"`
-	OwnerIP          string    `json:"owner_ip"`
+	FileName         string      `json:"file_name"`
+	Extension        string      `json:"extension"`
+	Size             int64       `json:"size"`
+	ModificationDate time.Time   `json:"modification_date"`
+	Version          int         `json:"version"` // Suma de Clock; se conserva como número de versión legible.
+	Clock            VectorClock `json:"clock,omitempty"`
+	TTL              int         `json:"ttl"`
+	OwnerIP          string      `json:"owner_ip"`
+	Deleted          bool        `json:"deleted,omitempty"`     // Lápida: el archivo fue eliminado.
+	Unavailable      bool        `json:"unavailable,omitempty"` // El dueño no encuentra el archivo en disco.
+	Replicas         []string    `json:"replicas,omitempty"`    // Peers que guardan copia de esta versión.
+	IsDir            bool        `json:"is_dir,omitempty"`      // La entrada es una carpeta del espacio de nombres.
+	ACL              *FileACL    `json:"acl,omitempty"`         // Permisos por usuario; nil es acceso libre.
 }
 
 type FileUpdate struct {
-	FileName         string    `json:"file_name"`
-	Content          []byte    `json:"content"`
-	ModificationDate time.Time `json:"modification_date"`
-	Version          int       `json:"version"`
+	FileName         string      `json:"file_name"`
+	Content          []byte      `json:"content"`
+	ModificationDate time.Time   `json:"modification_date"`
+	Version          int         `json:"version"`
+	Clock            VectorClock `json:"clock,omitempty"`  // Reloj de la versión sobre la que se editó.
+	Origin           string      `json:"origin,omitempty"` // Nodo al que se atribuye la edición.
+	LockToken        string      `json:"lock_token,omitempty"`
 }
 
 type NetworkMessage struct {
-	Type          string          `json:"type"`
-	Payload       json.RawMessage `json:"payload,omitempty"`
-	Authoritative bool            `json:"authoritative"`
-	SenderIP      string          `json:"sender_ip"`
+	Type          string `json:"type"`
+	Payload       []byte `json:"payload,omitempty"`
+	Authoritative bool   `json:"authoritative"`
+	SenderIP      string `json:"sender_ip"`
+	Reason        string `json:"reason,omitempty"` // Código de motivo de un NACK (p. ej. INVALID_NAME).
 }
 
 type logEntry struct {
@@ -78,66 +89,131 @@ var (
 	// Nuevo mapa para rastrear copias locales para edición
 	localWorkUnitsMutex sync.RWMutex
 	localWorkUnits      = make(map[string]string) // key: filename, value: originalOwnerIP
+	// deletePolicy decide cuántos peers deben confirmar antes de borrar una entrada vencida.
+	deletePolicy = "quorum"
 )
 
+// ttlExpired marca una entrada cuyo TTL venció y que espera la confirmación de
+// los demás servidores de nombres. TTL 0 significa que la entrada no expira.
+const ttlExpired = -1
+
+// acceptWorkers es el número de goroutines que aceptan conexiones a la vez.
+const acceptWorkers = 16
+
+// handshakeTimeout limita cada handshake DTLS, entrante o saliente.
+const handshakeTimeout = 10 * time.Second
+
+// cleaner descuenta el TTL de las entradas y verifica las vencidas con todos los
+// peers vivos antes de borrarlas.
 func cleaner() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 	for range ticker.C {
 		logEvent("SERVER_CLEANER", "SCAN_START", "Iniciando escaneo de archivos compartidos.")
-		keysToDelete := []string{}
-		
-		sharedFilesMutex.RLock()
+		markMissingOwnedFiles()
+
+		var expired []DirectoryEntry
+		sharedFilesMutex.Lock()
 		for key, entry := range sharedFiles {
+			if entry.Deleted || entry.TTL == 0 {
+				continue
+			}
 			if entry.TTL > 0 {
-				sharedFilesMutex.RUnlock()
-				sharedFilesMutex.Lock()
 				entry.TTL -= 30
-				sharedFiles[key] = entry
-				sharedFilesMutex.Unlock()
-				sharedFilesMutex.RLock()
-
-				logEvent("SERVER_CLEANER", "TTL_UPDATE", fmt.Sprintf("Actualizado TTL para '%s', nuevo TTL: %d", key, entry.TTL))
-
 				if entry.TTL <= 0 {
+					entry.TTL = ttlExpired
 					logEvent("SERVER_CLEANER", "TTL_EXPIRED", fmt.Sprintf("TTL expirado para '%s'. Verificando con otros peers...", key))
-					foundNewOwner := false
-					peersToCheck := gossipProtocol.GetRandomPeers(1)
-					if len(peersToCheck) > 0 {
-						newEntry, err := gossipProtocol.RequestStatus(key, peersToCheck[0])
-						if err == nil {
-							sharedFilesMutex.RUnlock()
-							sharedFilesMutex.Lock()
-							sharedFiles[key] = *newEntry
-							sharedFilesMutex.Unlock()
-							sharedFilesMutex.RLock()
-							logEvent("SERVER_CLEANER", "OWNER_CHANGE", fmt.Sprintf("Se encontró un nuevo dueño para '%s': %s. Actualizando registro.", key, newEntry.OwnerIP))
-							foundNewOwner = true
-						}
-					}
-					if !foundNewOwner {
-						keysToDelete = append(keysToDelete, key)
-					}
+				} else {
+					logEvent("SERVER_CLEANER", "TTL_UPDATE", fmt.Sprintf("Actualizado TTL para '%s', nuevo TTL: %d", key, entry.TTL))
 				}
+				sharedFiles[key] = entry
+				dirStore.Put(entry)
+			}
+			if entry.TTL == ttlExpired {
+				expired = append(expired, entry)
 			}
-		}
-		sharedFilesMutex.RUnlock()
-		
-		sharedFilesMutex.Lock()
-		for _, key := range keysToDelete {
-			logEvent("SERVER_CLEANER", "RECORD_DELETE", fmt.Sprintf("Registro para '%s' eliminado. Nadie tiene una copia autoritativa.", key))
-			delete(sharedFiles, key)
 		}
 		sharedFilesMutex.Unlock()
+
+		for _, entry := range expired {
+			verifyExpiredEntry(entry, gossipProtocol.SurveyStatus(entry.FileName))
+		}
 	}
 }
 
+// verifyExpiredEntry decide qué hacer con una entrada vencida según lo que
+// respondieron los peers. Si alguno se declara dueño, su entrada reemplaza a la
+// local (regla de cambio de dueño). Si no, sólo se borra cuando los NACK
+// alcanzan deleteQuorum; con menos respuestas se reintenta en la siguiente vuelta.
+func verifyExpiredEntry(entry DirectoryEntry, survey statusSurvey) {
+	key := entry.FileName
+
+	var owner *DirectoryEntry
+	for i, candidate := range survey.Authoritative {
+		if owner == nil {
+			owner = &survey.Authoritative[i]
+			continue
+		}
+		switch entryClock(candidate).Compare(entryClock(*owner)) {
+		case clockAfter:
+			owner = &survey.Authoritative[i]
+		case clockConcurrent, clockEqual:
+			if candidate.OwnerIP < owner.OwnerIP {
+				owner = &survey.Authoritative[i]
+			}
+		}
+	}
+
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	current, found := sharedFiles[key]
+	if !found || current.TTL != ttlExpired || entryClock(current).Compare(entryClock(entry)) != clockEqual {
+		logEvent("SERVER_CLEANER", "RECORD_REFRESHED", fmt.Sprintf("'%s' cambió durante la verificación; se conserva.", key))
+		return
+	}
+
+	if owner != nil && owner.TTL == ttlExpired {
+		// El dueño también está verificando su registro vencido: se espera su decisión.
+		logEvent("SERVER_CLEANER", "DELETE_DEFERRED", fmt.Sprintf("'%s' no se elimina: su dueño %s aún no resuelve su propio vencimiento.", key, owner.OwnerIP))
+		return
+	}
+	if owner != nil {
+		sharedFiles[key] = *owner
+		dirStore.Put(*owner)
+		if owner.OwnerIP == entry.OwnerIP {
+			logEvent("SERVER_CLEANER", "TTL_REFRESHED", fmt.Sprintf("%s confirmó ser dueño de '%s'. TTL renovado a %d.", owner.OwnerIP, key, owner.TTL))
+		} else {
+			logEvent("SERVER_CLEANER", "OWNER_CHANGE", fmt.Sprintf("Se encontró un nuevo dueño para '%s': %s. Actualizando registro.", key, owner.OwnerIP))
+		}
+		return
+	}
+
+	required := deleteQuorum(survey.Asked)
+	if survey.Nacks < required {
+		logEvent("SERVER_CLEANER", "DELETE_DEFERRED", fmt.Sprintf("'%s' no se elimina: %d de %d peers confirmaron (se requieren %d, %d sin respuesta).", key, survey.Nacks, survey.Asked, required, survey.Failures))
+		return
+	}
+	delete(sharedFiles, key)
+	dirStore.Delete(key)
+	logEvent("SERVER_CLEANER", "RECORD_DELETE", fmt.Sprintf("Registro para '%s' eliminado. %d de %d peers confirmaron que nadie tiene una copia autoritativa.", key, survey.Nacks, survey.Asked))
+}
+
+// deleteQuorum es el número de NACK necesarios para borrar una entrada vencida
+// cuando se consultaron asked peers, según la política -delete-policy.
+func deleteQuorum(asked int) int {
+	if asked == 0 || deletePolicy == "unanimous" {
+		return asked
+	}
+	return asked/2 + 1
+}
+
 func initServerData(selfAddr string) {
 	sharedFiles["test_file.txt"] = DirectoryEntry{
 		FileName:         "test_file.txt",
 		Size:             123,
 		ModificationDate: time.Now(),
 		Version:          1,
+		Clock:            VectorClock{selfAddr: 1},
 		TTL:              60,
 		OwnerIP:          selfAddr,
 	}
@@ -146,16 +222,71 @@ func initServerData(selfAddr string) {
 		Size:             456,
 		ModificationDate: time.Now(),
 		Version:          1,
+		Clock:            VectorClock{selfAddr: 1},
 		TTL:              0,
 		OwnerIP:          selfAddr,
 	}
+	for _, entry := range sharedFiles {
+		dirStore.Put(entry)
+	}
 	logEvent("SERVER", "DIRECTORY_INIT", "Directorio inicializado con archivos de prueba.")
 }
 
+// main inicia el servidor. Ejecutar con: go run server.go gossip.go framing.go transfer.go persistence.go share.go watcher.go tombstone.go vclock.go update.go versions.go merge.go lock.go replica.go failover.go proxy.go membership.go swim.go antientropy.go rumor.go storage.go namespace.go acl.go revocation.go
 func main() {
 	port := flag.String("port", "8080", "Puerto para que el servidor escuche")
 	peersStr := flag.String("peers", "", "Lista de peers iniciales, separados por comas (ej: localhost:8081,localhost:8082)")
+	statePrefix := flag.String("state", "", "Prefijo de los archivos de estado persistente (por defecto directory_<puerto>)")
+	storageRootFlag := flag.String("storage-root", "", "Carpeta donde se guardan los archivos del DFS (por defecto storage_<puerto>)")
+	shareDirFlag := flag.String("share-dir", "", "Carpeta cuyos archivos se publican al iniciar; sustituye a -storage-root")
+	shareConfigPath := flag.String("share-config", "", "Archivo JSON con patrones include/exclude y TTL para -share-dir")
+	tombstoneGrace := flag.Duration("tombstone-grace", 24*time.Hour, "Tiempo que se conserva una lápida antes de eliminarla")
+	sharePoll := flag.Duration("share-poll", 10*time.Second, "Intervalo con el que se revisan cambios en -share-dir")
+	deletePolicyFlag := flag.String("delete-policy", "quorum", "Confirmaciones necesarias para borrar una entrada vencida: quorum o unanimous")
+	readPolicyFlag := flag.String("read-policy", "proxy", "Lectura de archivos ajenos: proxy (se piden al dueño) o redirect (el cliente va al dueño)")
+	gossipFanout := flag.Int("gossip-fanout", 3, "Número de peers a los que se reenvía cada rumor")
+	gossipRounds := flag.Int("gossip-rounds", 0, "Saltos máximos de un rumor (0: según el tamaño del cluster)")
+	aclAdminGroupFlag := flag.String("acl-admin-group", aclAdminGroup, "Grupo (OU del certificado) que administra las entradas sin ACL, como las de -share-dir")
+	revokedPath := flag.String("revoked", "revoked_serials.txt", "Lista de números de serie de certificados revocados; se vuelve a leer cuando cambia")
+	revokedReload := flag.Duration("revoked-reload", 30*time.Second, "Intervalo con el que se revisa si cambió la lista de revocados")
+	replicaCount := flag.Int("replicas", 2, "Número de peers que guardan copia de cada archivo propio")
+	versionsKeep := flag.Int("versions-keep", 10, "Número de versiones que se conservan por archivo")
+	versionsDir := flag.String("versions-dir", "", "Carpeta con las versiones guardadas de los archivos propios (por defecto .versions_<puerto>)")
 	flag.Parse()
+	replicationFactor = *replicaCount
+	rumorFanout = *gossipFanout
+	rumorRounds = *gossipRounds
+	aclAdminGroup = *aclAdminGroupFlag
+	if *deletePolicyFlag != "quorum" && *deletePolicyFlag != "unanimous" {
+		panic(fmt.Sprintf("-delete-policy inválida: %s", *deletePolicyFlag))
+	}
+	deletePolicy = *deletePolicyFlag
+	if *readPolicyFlag != "proxy" && *readPolicyFlag != "redirect" {
+		panic(fmt.Sprintf("-read-policy inválida: %s", *readPolicyFlag))
+	}
+	readPolicy = *readPolicyFlag
+	if *statePrefix == "" {
+		*statePrefix = fmt.Sprintf("directory_%s", *port)
+	}
+	if *versionsDir == "" {
+		*versionsDir = fmt.Sprintf(".versions_%s", *port)
+	}
+	if *storageRootFlag == "" {
+		*storageRootFlag = fmt.Sprintf("storage_%s", *port)
+	}
+	shareDir = *storageRootFlag
+	if *shareDirFlag != "" {
+		shareDir = *shareDirFlag
+	}
+	if err := ensureStorageRoot(); err != nil {
+		logEvent("SERVER", "ERROR", err.Error())
+		panic(err)
+	}
+	replicasDir := fmt.Sprintf(".replicas_%s", *port)
+	proxyCacheDir := fmt.Sprintf(".proxy_cache_%s", *port)
+	for _, internal := range []string{*statePrefix + ".wal", *statePrefix + ".snapshot.json", *versionsDir, replicasDir, proxyCacheDir, *revokedPath} {
+		protectPath(internal)
+	}
 
 	selfAddr = fmt.Sprintf("127.0.0.1:%s", *port)
 	var knownPeers []string
@@ -165,7 +296,7 @@ func main() {
 
 	cert, err := tls.LoadX509KeyPair("server.crt", "server.key")
 	if err != nil {
-		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al cargar el par de claves: %v. Asegúrate de haber ejecutado 'setup_ca.go'.", err))
+		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al cargar el par de claves: %v. Emítelo con 'go run ./dfs-ca issue-server -ip <ip>'.", err))
 		panic(err)
 	}
 	caCert, err := os.ReadFile("ca.crt")
@@ -180,10 +311,24 @@ func main() {
 	}
 	logEvent("SERVER", "CERT_LOADED", "Certificado y clave de servidor cargados.")
 
+	caPool = roots
+	if _, err := loadRevocationList(*revokedPath); err != nil {
+		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al cargar la lista de revocados: %v", err))
+		panic(err)
+	}
+
+	// Autenticación mutua: todo cliente o peer debe presentar un certificado
+	// de la CA que no esté revocado (verifyPeerCertificate).
 	dtlsConfig := &dtls.Config{
-		Certificates:         []tls.Certificate{cert},
-		RootCAs:              roots,
-		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		Certificates:          []tls.Certificate{cert},
+		RootCAs:               roots,
+		ClientAuth:            dtls.RequireAnyClientCert,
+		ClientCAs:             roots,
+		VerifyPeerCertificate: verifyPeerCertificate,
+		ExtendedMasterSecret:  dtls.RequireExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), handshakeTimeout)
+		},
 	}
 
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%s", *port))
@@ -192,15 +337,64 @@ func main() {
 		panic(err)
 	}
 
+	dirStore = openDirectoryStore(*statePrefix)
+	restored, err := dirStore.Replay()
+	if err != nil {
+		logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al recuperar el estado persistente: %v", err))
+		panic(err)
+	}
+	if len(restored) > 0 {
+		sharedFiles = restored
+		logEvent("SERVER", "DIRECTORY_RESTORED", fmt.Sprintf("Directorio recuperado con %d entradas desde '%s'.", len(restored), *statePrefix))
+	} else if *shareDirFlag == "" {
+		initServerData(selfAddr)
+	}
+	go dirStore.compactLoop(5 * time.Minute)
+
+	versions, err = openVersionStore(*versionsDir, *versionsKeep)
+	if err != nil {
+		logEvent("SERVER", "ERROR", err.Error())
+		panic(err)
+	}
+	replicas, err = openReplicaStore(replicasDir)
+	if err != nil {
+		logEvent("SERVER", "ERROR", err.Error())
+		panic(err)
+	}
+	proxyCache, err = openReplicaStore(proxyCacheDir)
+	if err != nil {
+		logEvent("SERVER", "ERROR", err.Error())
+		panic(err)
+	}
+
 	gossipProtocol, err = NewGossipProtocol(knownPeers, dtlsConfig, selfAddr)
 	if err != nil {
 		panic(err)
 	}
 
-	go gossipProtocol.StartGossipRoutine()
+	if *shareDirFlag != "" {
+		shareCfg, err := loadShareConfig(*shareConfigPath)
+		if err != nil {
+			logEvent("SERVER", "ERROR", err.Error())
+			panic(err)
+		}
+		changed, err := scanSharedDir(shareCfg)
+		if err != nil {
+			logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al recorrer la carpeta compartida '%s': %v", shareDir, err))
+			panic(err)
+		}
+		logEvent("SERVER", "DIRECTORY_INIT", fmt.Sprintf("Carpeta '%s' escaneada: %d archivos nuevos o modificados.", shareDir, len(changed)))
+		for _, entry := range changed {
+			gossipProtocol.GossipUpdateAllPeers(entry)
+		}
+		go watchSharedDir(shareCfg, *sharePoll)
+	}
 
-	initServerData(selfAddr)
+	go gossipProtocol.Join()
+	go gossipProtocol.StartGossipRoutine()
 	go cleaner()
+	go collectTombstones(*tombstoneGrace)
+	go watchRevocationList(*revokedPath, *revokedReload)
 
 	listener, err := dtls.Listen("udp", addr, dtlsConfig)
 	if err != nil {
@@ -210,30 +404,70 @@ func main() {
 	defer listener.Close()
 	logEvent("SERVER", "LISTENING", fmt.Sprintf("Servidor DTLS escuchando en el puerto %s...", *port))
 
-	var wg sync.WaitGroup
+	// El listener DTLS hace el handshake dentro de Accept: con varios
+	// aceptadores, un handshake abandonado (por ejemplo, el de un sondeo que
+	// expiró) no detiene a las conexiones que llegan detrás.
+	for i := 1; i < acceptWorkers; i++ {
+		go acceptConnections(listener)
+	}
+	acceptConnections(listener)
+}
+
+// acceptConnections acepta conexiones y atiende cada una en su goroutine.
+func acceptConnections(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al aceptar conexión: %v", err))
 			continue
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			handleClient(conn)
-		}()
+		go handleClient(conn)
+	}
+}
+
+// applyGossipUpdate aplica una entrada recibida de un peer (GOSSIP_UPDATE o rumor).
+func applyGossipUpdate(entry DirectoryEntry) {
+	sharedFilesMutex.Lock()
+	applied := mergeEntry(entry)
+	sharedFilesMutex.Unlock()
+	if applied {
+		logEvent("SERVER", "GOSSIP_UPDATE_RECEIVED", fmt.Sprintf("Recibida actualización de peer para '%s' (versión %d, eliminado: %t).", entry.FileName, entry.Version, entry.Deleted))
+	} else {
+		logEvent("SERVER", "GOSSIP_UPDATE_IGNORED", fmt.Sprintf("Ignorada actualización de peer para '%s': la versión local es igual o más reciente.", entry.FileName))
+	}
+}
+
+// applyOwnershipUpdate aplica un cambio de dueño (FILE_COPY_UPDATE o rumor) si
+// es más reciente que la entrada local.
+func applyOwnershipUpdate(updatedEntry DirectoryEntry) {
+	logEvent("SERVER", "FILE_UPDATE", fmt.Sprintf("Recibida actualización para '%s'", updatedEntry.FileName))
+	if err := validateFileName(updatedEntry.FileName); err != nil {
+		logEvent("SERVER", "INVALID_NAME", fmt.Sprintf("Ignorado cambio de dueño con nombre '%s': %v", updatedEntry.FileName, err))
+		return
+	}
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	originalEntry, found := sharedFiles[updatedEntry.FileName]
+	if !found || entryClock(updatedEntry).Compare(entryClock(originalEntry)) == clockAfter {
+		sharedFiles[updatedEntry.FileName] = updatedEntry
+		dirStore.Put(updatedEntry)
+		logEvent("SERVER", "UPDATE_SUCCESS", fmt.Sprintf("Archivo '%s' actualizado con éxito. Nuevo dueño: %s, Versión: %d", updatedEntry.FileName, updatedEntry.OwnerIP, updatedEntry.Version))
+	} else {
+		logEvent("SERVER", "UPDATE_REJECTED", fmt.Sprintf("Rechazada actualización de '%s'. La versión local es más reciente (%d) o igual (%d).", updatedEntry.FileName, originalEntry.Version, updatedEntry.Version))
 	}
 }
 
 func handleClient(conn net.Conn) {
 	clientAddr := conn.RemoteAddr().String()
-	logEvent("SERVER", "NEW_CONNECTION", fmt.Sprintf("Conexión aceptada de %s", clientAddr))
+	identity := peerIdentity(conn)
+	logEvent("SERVER", "NEW_CONNECTION", fmt.Sprintf("Conexión aceptada de %s (%s)", clientAddr, identity))
+	registerSession(conn, identity.Serial)
+	defer unregisterSession(conn)
 
 	for {
-		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		setReadDeadline(conn, time.Now().Add(5*time.Minute))
 
-		buffer := make([]byte, 2048)
-		n, err := conn.Read(buffer)
+		msg, err := readMessage(conn)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				logEvent("SERVER", "CONNECTION_CLOSED", fmt.Sprintf("Conexión con %s cerrada por inactividad: %v", clientAddr, err))
@@ -244,25 +478,31 @@ func handleClient(conn net.Conn) {
 			return
 		}
 
-		var msg NetworkMessage
-		if err := json.Unmarshal(buffer[:n], &msg); err != nil {
-			logEvent("SERVER", "ERROR", fmt.Sprintf("Mensaje malformado de %s: %v", clientAddr, err))
-			conn.Close()
-			return
-		}
-
 		logEvent("SERVER", "MESSAGE_RECEIVED", fmt.Sprintf("De %s, tipo: %s", clientAddr, msg.Type))
 
+		if denied := authorize(msg, identity, conn.LocalAddr().String()); denied != nil {
+			if err := writeMessage(conn, *denied); err != nil {
+				logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al enviar respuesta a %s: %v", clientAddr, err))
+				conn.Close()
+				return
+			}
+			continue
+		}
+
 		var responseMsg NetworkMessage
 		switch msg.Type {
 		case "GET_FILE_INFO":
 			var fileName string
 			json.Unmarshal(msg.Payload, &fileName)
 			logEvent("SERVER", "QUERY", fmt.Sprintf("Consulta de información para '%s'", fileName))
+			if nack := invalidNameResponse(fileName, conn.LocalAddr().String()); nack != nil {
+				responseMsg = *nack
+				break
+			}
 			sharedFilesMutex.RLock()
 			entry, found := sharedFiles[fileName]
 			sharedFilesMutex.RUnlock()
-			if found {
+			if found && !entry.Deleted {
 				payloadBytes, _ := json.Marshal(entry)
 				responseMsg = NetworkMessage{
 					Type:          "RESPONSE",
@@ -279,9 +519,13 @@ func handleClient(conn net.Conn) {
 				}
 			}
 		case "GET_FULL_LIST":
-			logEvent("SERVER", "QUERY_LIST", "Solicitud de lista completa")
+			// El payload opcional es la carpeta cuyo subárbol se pide; sin él,
+			// se devuelve el directorio completo.
+			var subtree string
+			json.Unmarshal(msg.Payload, &subtree)
+			logEvent("SERVER", "QUERY_LIST", fmt.Sprintf("Solicitud de lista del subárbol '%s'", subtree))
 			sharedFilesMutex.RLock()
-			payloadBytes, _ := json.Marshal(sharedFiles)
+			payloadBytes, _ := json.Marshal(subtreeEntries(subtree))
 			sharedFilesMutex.RUnlock()
 			responseMsg = NetworkMessage{
 				Type:          "RESPONSE_LIST",
@@ -290,60 +534,49 @@ func handleClient(conn net.Conn) {
 				SenderIP:      conn.LocalAddr().String(),
 			}
 		case "ADD_FILE":
-			var fileName string
-			json.Unmarshal(msg.Payload, &fileName)
-			logEvent("SERVER", "ADD_FILE_REQUEST", fmt.Sprintf("Petición para agregar el archivo '%s'.", fileName))
-			sharedFilesMutex.Lock()
-			file, err := os.Create(fileName)
-			if err != nil {
-				sharedFilesMutex.Unlock()
-				logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al crear el archivo '%s': %v", fileName, err))
-				responseMsg = NetworkMessage{
-					Type:    "NACK",
-					Payload: []byte("Error al crear el archivo."),
-				}
-			} else {
-				file.Close()
-				newEntry := DirectoryEntry{
-					FileName:         fileName,
-					Size:             0,
-					ModificationDate: time.Now(),
-					Version:          1,
-					TTL:              3600,
-					OwnerIP:          selfAddr,
-				}
-				sharedFiles[fileName] = newEntry
-				sharedFilesMutex.Unlock()
-				logEvent("SERVER", "NEW_FILE_ADDED", fmt.Sprintf("Nuevo archivo '%s' agregado a la lista local.", fileName))
-				go gossipProtocol.GossipUpdateAllPeers(newEntry)
-				responseMsg = NetworkMessage{
-					Type:          "UPDATE_ACK",
-					Payload:       []byte("Archivo agregado y compartido."),
-					Authoritative: true,
-					SenderIP:      conn.LocalAddr().String(),
-				}
-			}
+			responseMsg = handleAddFile(msg, conn.LocalAddr().String(), identity)
 		case "REQUEST_FILE":
 			var fileName string
 			json.Unmarshal(msg.Payload, &fileName)
 			logEvent("SERVER", "FILE_REQUEST", fmt.Sprintf("Solicitud de archivo '%s' recibida.", fileName))
+			if nack := invalidNameResponse(fileName, conn.LocalAddr().String()); nack != nil {
+				responseMsg = *nack
+				break
+			}
 			sharedFilesMutex.RLock()
 			entry, found := sharedFiles[fileName]
 			sharedFilesMutex.RUnlock()
-			if found && entry.OwnerIP == selfAddr {
-				fileContent, err := os.ReadFile(fileName)
+			found = found && !entry.Deleted
+			if found && entry.IsDir {
+				responseMsg = namespaceNack(conn.LocalAddr().String(), "'%s' es una carpeta.", fileName)
+			} else if found && entry.OwnerIP == selfAddr && entry.Unavailable {
+				responseMsg = NetworkMessage{
+					Type:          "NACK",
+					Payload:       []byte("El archivo no está disponible en el servidor dueño."),
+					Authoritative: true,
+					SenderIP:      conn.LocalAddr().String(),
+				}
+			} else if replicaPath, servable := servingPath(entry, !identity.Server); found && servable {
+				fileContent, err := os.ReadFile(replicaPath)
 				if err != nil {
 					logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al leer el archivo '%s': %v", fileName, err))
 					responseMsg = NetworkMessage{
 						Type:    "NACK",
 						Payload: []byte("Error al leer el archivo."),
 					}
-				} else {
+				} else if entry.OwnerIP == selfAddr {
+					versions.Record(fileName, entry, fileContent)
 					responseMsg = NetworkMessage{
 						Type:    "FILE_RESPONSE",
 						Payload: fileContent,
 					}
 					logEvent("SERVER", "FILE_SENT", fmt.Sprintf("Archivo '%s' enviado a %s.", fileName, conn.RemoteAddr()))
+				} else {
+					responseMsg = NetworkMessage{
+						Type:    "FILE_RESPONSE",
+						Payload: fileContent,
+					}
+					logEvent("SERVER", "FILE_SENT_FROM_COPY", fmt.Sprintf("Archivo '%s' (versión %d) de %s enviado a %s desde una copia local.", fileName, entry.Version, entry.OwnerIP, conn.RemoteAddr()))
 				}
 			} else if found {
 				responseMsg = NetworkMessage{
@@ -359,60 +592,50 @@ func handleClient(conn net.Conn) {
 					SenderIP:      conn.LocalAddr().String(),
 				}
 			}
+		case "DELETE_FILE":
+			responseMsg = handleDeleteFile(msg, conn.LocalAddr().String())
+		case "REQUEST_FILE_CHUNK":
+			responseMsg = handleFileChunkRequest(msg, conn.LocalAddr().String(), identity)
 		case "FILE_WRITE_UPDATE":
-			var fileUpdate FileUpdate
-			json.Unmarshal(msg.Payload, &fileUpdate)
-			logEvent("SERVER", "FILE_WRITE_UPDATE", fmt.Sprintf("Recibida actualización para '%s' desde %s.", fileUpdate.FileName, conn.RemoteAddr()))
-			sharedFilesMutex.Lock()
-			entry, found := sharedFiles[fileUpdate.FileName]
-			if !found || fileUpdate.Version < entry.Version {
-				sharedFilesMutex.Unlock()
-				responseMsg = NetworkMessage{
-					Type:          "UPDATE_REJECTED",
-					Payload:       []byte("Actualización rechazada: la versión local es más reciente."),
-					Authoritative: true,
-					SenderIP:      conn.LocalAddr().String(),
-				}
-				logEvent("SERVER", "UPDATE_REJECTED", fmt.Sprintf("Rechazada actualización de '%s'. La versión del cliente (%d) es más antigua que la local (%d).", fileUpdate.FileName, fileUpdate.Version, entry.Version))
-			} else {
-				if entry.ModificationDate.After(fileUpdate.ModificationDate) {
-					sharedFilesMutex.Unlock()
-					logEvent("SERVER", "COLLISION_DETECTED", fmt.Sprintf("Colisión en '%s'. La versión del servidor (%v) es más reciente que la del cliente (%v).", fileUpdate.FileName, entry.ModificationDate, fileUpdate.ModificationDate))
-					responseMsg = NetworkMessage{
-						Type:          "UPDATE_REJECTED",
-						Payload:       []byte("Actualización rechazada por colisión. La versión del servidor es más reciente."),
-						Authoritative: true,
-						SenderIP:      conn.LocalAddr().String(),
-					}
-				} else {
-					err := os.WriteFile(fileUpdate.FileName, fileUpdate.Content, 0644)
-					if err != nil {
-						sharedFilesMutex.Unlock()
-						logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al escribir en el archivo '%s': %v", fileUpdate.FileName, err))
-						responseMsg = NetworkMessage{
-							Type: "NACK",
-							Payload: []byte("Error al escribir el archivo."),
-						}
-					} else {
-						entry.Version = fileUpdate.Version + 1
-						entry.Size = int64(len(fileUpdate.Content))
-						entry.ModificationDate = time.Now()
-						sharedFiles[fileUpdate.FileName] = entry
-						sharedFilesMutex.Unlock()
-						responseMsg = NetworkMessage{
-							Type:          "UPDATE_ACK",
-							Payload:       []byte("Archivo actualizado con éxito."),
-							Authoritative: true,
-							SenderIP:      conn.LocalAddr().String(),
-						}
-						logEvent("SERVER", "UPDATE_SUCCESS", fmt.Sprintf("Archivo '%s' actualizado con éxito. Nueva versión: %d", fileUpdate.FileName, entry.Version))
-					}
-				}
-			}
+			responseMsg = handleFileWriteUpdate(msg, conn.LocalAddr().String(), clientAddr, identity)
+		case "ACQUIRE_LOCK", "RENEW_LOCK", "RELEASE_LOCK":
+			responseMsg = handleLockMessage(msg, conn.LocalAddr().String(), clientAddr)
+		case "SYNC_TREE":
+			responseMsg = handleSyncTree(msg, conn.LocalAddr().String())
+		case "SYNC_BUCKET":
+			responseMsg = handleSyncBucket(msg, conn.LocalAddr().String())
+		case "JOIN":
+			responseMsg = gossipProtocol.HandleJoin(msg, conn.LocalAddr().String())
+		case "MEMBERSHIP":
+			responseMsg = gossipProtocol.HandleMembership(msg, conn.LocalAddr().String())
+		case "PING":
+			responseMsg = gossipProtocol.HandlePing(msg, conn.LocalAddr().String())
+		case "PING_REQ":
+			responseMsg = gossipProtocol.HandlePingReq(msg, conn.LocalAddr().String())
+		case "REPLICA_PUSH":
+			responseMsg = handleReplicaPush(msg, conn.LocalAddr().String())
+		case "LIST_VERSIONS":
+			responseMsg = handleListVersions(msg, conn.LocalAddr().String())
+		case "GET_VERSION":
+			responseMsg = handleGetVersion(msg, conn.LocalAddr().String())
+		case "ROLLBACK":
+			responseMsg = handleRollback(msg, conn.LocalAddr().String())
+		case "MKDIR":
+			responseMsg = handleMkdir(msg, conn.LocalAddr().String(), identity)
+		case "LIST_DIR":
+			responseMsg = handleListDir(msg, conn.LocalAddr().String())
+		case "MOVE":
+			responseMsg = handleMove(msg, conn.LocalAddr().String())
+		case "SET_ACL":
+			responseMsg = handleSetACL(msg, conn.LocalAddr().String(), identity)
 		case "REQUEST_STATUS":
 			var fileName string
 			json.Unmarshal(msg.Payload, &fileName)
 			logEvent("SERVER", "STATUS_REQUEST", fmt.Sprintf("Petición de estado para '%s' de peer %s.", fileName, conn.RemoteAddr()))
+			if nack := invalidNameResponse(fileName, conn.LocalAddr().String()); nack != nil {
+				responseMsg = *nack
+				break
+			}
 			sharedFilesMutex.RLock()
 			entry, found := sharedFiles[fileName]
 			sharedFilesMutex.RUnlock()
@@ -435,29 +658,21 @@ func handleClient(conn net.Conn) {
 		case "GOSSIP_UPDATE":
 			var entry DirectoryEntry
 			json.Unmarshal(msg.Payload, &entry)
-			sharedFilesMutex.Lock()
-			sharedFiles[entry.FileName] = entry
-			sharedFilesMutex.Unlock()
-			logEvent("SERVER", "GOSSIP_UPDATE_RECEIVED", fmt.Sprintf("Recibida actualización de peer para '%s'.", entry.FileName))
+			applyGossipUpdate(entry)
 		case "FILE_COPY_UPDATE":
 			var updatedEntry DirectoryEntry
 			json.Unmarshal(msg.Payload, &updatedEntry)
-			logEvent("SERVER", "FILE_UPDATE", fmt.Sprintf("Recibida actualización para '%s'", updatedEntry.FileName))
-			sharedFilesMutex.Lock()
-			originalEntry, found := sharedFiles[updatedEntry.FileName]
-			if !found || updatedEntry.Version > originalEntry.Version {
-				sharedFiles[updatedEntry.FileName] = updatedEntry
-				logEvent("SERVER", "UPDATE_SUCCESS", fmt.Sprintf("Archivo '%s' actualizado con éxito. Nuevo dueño: %s, Versión: %d", updatedEntry.FileName, updatedEntry.OwnerIP, updatedEntry.Version))
-			} else {
-				logEvent("SERVER", "UPDATE_REJECTED", fmt.Sprintf("Rechazada actualización de '%s'. La versión local es más reciente (%d) o igual (%d).", updatedEntry.FileName, originalEntry.Version, updatedEntry.Version))
-			}
-			sharedFilesMutex.Unlock()
+			applyOwnershipUpdate(updatedEntry)
 			responseMsg = NetworkMessage{
 				Type:          "UPDATE_ACK",
 				Payload:       []byte("Actualización recibida y procesada."),
 				Authoritative: true,
 				SenderIP:      conn.LocalAddr().String(),
 			}
+		case "RUMOR":
+			responseMsg = handleRumor(msg, conn.LocalAddr().String())
+		case "GET_METRICS":
+			responseMsg = handleGetMetrics(conn.LocalAddr().String())
 		default:
 			responseMsg = NetworkMessage{
 				Type:          "NACK",
@@ -467,8 +682,11 @@ func handleClient(conn net.Conn) {
 			}
 		}
 
-		responseBytes, _ := json.Marshal(responseMsg)
-		conn.Write(responseBytes)
+		if err := writeMessage(conn, responseMsg); err != nil {
+			logEvent("SERVER", "ERROR", fmt.Sprintf("Falla al enviar respuesta a %s: %v", clientAddr, err))
+			conn.Close()
+			return
+		}
 		logEvent("SERVER", "MESSAGE_SENT", fmt.Sprintf("Respuesta enviada de tipo: %s", responseMsg.Type))
 
 	}
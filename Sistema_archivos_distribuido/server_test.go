@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain ejecuta las pruebas dentro de una carpeta temporal, que hace de raíz
+// de almacenamiento, para que server.log, el WAL y los archivos de prueba no
+// caigan en el repositorio. Las pruebas se compilan con los mismos archivos que
+// el servidor (ver main) más los *_test.go: go test server.go ... *_test.go
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dfs-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	selfAddr = "127.0.0.1:9000"
+	dirStore = openDirectoryStore(filepath.Join(dir, ".state"))
+	gossipProtocol, _ = NewGossipProtocol(nil, nil, selfAddr)
+	if versions, err = openVersionStore(filepath.Join(dir, ".versions"), 10); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// resetDirectory vacía el directorio compartido antes de una prueba.
+func resetDirectory(entries ...DirectoryEntry) {
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	sharedFiles = make(map[string]DirectoryEntry)
+	for _, entry := range entries {
+		sharedFiles[entry.FileName] = entry
+	}
+}
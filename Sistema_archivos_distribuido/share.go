@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// shareConfig define qué archivos de la carpeta compartida se publican.
+// Los patrones usan la sintaxis de path.Match y se comparan tanto con el nombre
+// del archivo como con su ruta relativa a la carpeta compartida.
+type shareConfig struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	TTL     int      `json:"ttl"`
+}
+
+// defaultShareExcludes evita publicar credenciales, logs y estado del propio servidor.
+var defaultShareExcludes = []string{".*", "*.key", "*.crt", "*.log", "*.wal", "*.snapshot.json", "*.tmp"}
+
+// shareDir es la raíz de almacenamiento: la carpeta donde viven los archivos
+// propios del servidor. Los nombres lógicos se traducen con localPath sólo
+// después de validarlos con storagePath (storage.go).
+var shareDir = "."
+
+// reportedConflicts evita repetir en cada escaneo el aviso de un mismo conflicto.
+var reportedConflicts = make(map[string]bool)
+
+// localPath traduce el nombre lógico de un archivo a su ruta en disco. El
+// nombre debe estar validado: los handlers lo comprueban con
+// invalidNameResponse y mergeEntry descarta los de peers que no lo estén.
+func localPath(fileName string) string {
+	return filepath.Join(shareDir, filepath.FromSlash(fileName))
+}
+
+// fileModTime devuelve la fecha de modificación en disco de un archivo propio,
+// para que el watcher no lo confunda con un cambio externo.
+func fileModTime(fileName string) time.Time {
+	info, err := os.Stat(localPath(fileName))
+	if err != nil {
+		return time.Now()
+	}
+	return info.ModTime()
+}
+
+// loadShareConfig lee la configuración de inclusión/exclusión. Sin archivo se
+// publican todos los archivos salvo los excluidos por defecto.
+func loadShareConfig(configPath string) (shareConfig, error) {
+	cfg := shareConfig{TTL: 3600}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return cfg, fmt.Errorf("falla al leer la configuración '%s': %v", configPath, err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("configuración '%s' malformada: %v", configPath, err)
+		}
+	}
+	cfg.Exclude = append(cfg.Exclude, defaultShareExcludes...)
+	return cfg, nil
+}
+
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, path.Base(relPath)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, relPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allows indica si una ruta relativa (con '/') debe publicarse. Los
+// directorios sólo se filtran por las exclusiones.
+func (cfg shareConfig) allows(relPath string, isDir bool) bool {
+	if matchAny(cfg.Exclude, relPath) {
+		return false
+	}
+	if isDir || len(cfg.Include) == 0 {
+		return true
+	}
+	return matchAny(cfg.Include, relPath)
+}
+
+// publishLocalDir publica como carpeta del DFS una carpeta del disco que el
+// directorio aún no conoce. Si ya existe una entrada, aunque sea de otro dueño
+// o una lápida, se respeta: la carpeta en disco puede venir de ADD_FILE dentro
+// de una carpeta ajena o haber quedado tras borrarla.
+func publishLocalDir(rel string) *DirectoryEntry {
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	if _, found := sharedFiles[rel]; found {
+		return nil
+	}
+	entry := DirectoryEntry{
+		FileName:         rel,
+		IsDir:            true,
+		ModificationDate: fileModTime(rel),
+		OwnerIP:          selfAddr,
+	}
+	bumpVersion(&entry, selfAddr)
+	sharedFiles[rel] = entry
+	dirStore.Put(entry)
+	return &entry
+}
+
+// scanSharedDir recorre la carpeta compartida, verifica que cada archivo se
+// pueda leer y crea o actualiza su DirectoryEntry. Devuelve las entradas nuevas
+// o modificadas para que se anuncien a los peers.
+func scanSharedDir(cfg shareConfig) ([]DirectoryEntry, error) {
+	var changed []DirectoryEntry
+	err := filepath.WalkDir(shareDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logEvent("SERVER", "SHARE_SCAN_ERROR", fmt.Sprintf("No se pudo acceder a '%s': %v", filePath, err))
+			return nil
+		}
+		if filePath == shareDir {
+			return nil
+		}
+		rel, err := filepath.Rel(shareDir, filePath)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if !cfg.allows(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if _, err := storagePath(rel); err != nil {
+			logEvent("SERVER", "INVALID_NAME", fmt.Sprintf("Se omite '%s': %v", rel, err))
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if entry := publishLocalDir(rel); entry != nil {
+				changed = append(changed, *entry)
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			logEvent("SERVER", "SHARE_SCAN_ERROR", fmt.Sprintf("Falla al leer atributos de '%s': %v", rel, err))
+			return nil
+		}
+		// Verifica que el archivo esté realmente disponible antes de publicarlo.
+		file, err := os.Open(filePath)
+		if err != nil {
+			logEvent("SERVER", "SHARE_UNAVAILABLE", fmt.Sprintf("Archivo '%s' no disponible: %v", rel, err))
+			return nil
+		}
+		file.Close()
+
+		sharedFilesMutex.Lock()
+		defer sharedFilesMutex.Unlock()
+		entry, found := sharedFiles[rel]
+		if found && !entry.Deleted && entry.OwnerIP != selfAddr {
+			if !reportedConflicts[rel] {
+				reportedConflicts[rel] = true
+				logEvent("SERVER", "SHARE_CONFLICT", fmt.Sprintf("'%s' ya está publicado por %s; se omite la copia local.", rel, entry.OwnerIP))
+			}
+			return nil
+		}
+		if found && !entry.Deleted && entry.Size == info.Size() && entry.ModificationDate.Equal(info.ModTime()) {
+			return nil
+		}
+		if !found {
+			entry = DirectoryEntry{
+				FileName: rel,
+				TTL:      cfg.TTL,
+			}
+		}
+		// Un archivo que reaparece sobre una lápida la reemplaza con una versión mayor.
+		entry.OwnerIP = selfAddr
+		entry.Deleted = false
+		entry.Unavailable = false
+		entry.Extension = filepath.Ext(rel)
+		entry.Size = info.Size()
+		entry.ModificationDate = info.ModTime()
+		bumpVersion(&entry, selfAddr)
+		assignReplicas(&entry)
+		sharedFiles[rel] = entry
+		dirStore.Put(entry)
+		recordCurrentVersion(rel, entry)
+		go replicateFile(entry, nil)
+		changed = append(changed, entry)
+		return nil
+	})
+	return changed, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// nackInvalidName es el código de motivo (NetworkMessage.Reason) de los NACK
+// por nombres de archivo que no se pueden guardar en la raíz de almacenamiento.
+const nackInvalidName = "INVALID_NAME"
+
+// maxFileNameLength limita la longitud de un nombre lógico completo.
+const maxFileNameLength = 1024
+
+// reservedNames son nombres que nunca se aceptan como archivo del DFS, en
+// ninguna carpeta: las credenciales del nodo.
+var reservedNames = map[string]bool{
+	"server.key": true, "server.crt": true,
+	"client.key": true, "client.crt": true,
+	"ca.key": true, "ca.crt": true,
+}
+
+// deviceNames son los nombres de dispositivo que Windows no permite crear, con
+// o sin extensión.
+var deviceNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// protectedPaths son rutas absolutas del propio servidor (estado, versiones,
+// réplicas) que no pueden quedar al alcance de un nombre lógico aunque la raíz
+// de almacenamiento las contenga, por ejemplo con -share-dir ".".
+var (
+	protectedPathsMutex sync.RWMutex
+	protectedPaths      []string
+)
+
+// protectPath registra una ruta del servidor que los nombres lógicos no pueden tocar.
+func protectPath(p string) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return
+	}
+	protectedPathsMutex.Lock()
+	protectedPaths = append(protectedPaths, abs)
+	protectedPathsMutex.Unlock()
+}
+
+// validateFileName comprueba que un nombre lógico sea una ruta relativa limpia,
+// separada por "/", sin componentes "." o "..", ocultos ni reservados.
+func validateFileName(fileName string) error {
+	if fileName == "" {
+		return fmt.Errorf("el nombre está vacío")
+	}
+	if len(fileName) > maxFileNameLength {
+		return fmt.Errorf("el nombre supera %d bytes", maxFileNameLength)
+	}
+	for _, r := range fileName {
+		if r == '\\' || unicode.IsControl(r) {
+			return fmt.Errorf("el nombre contiene caracteres no permitidos")
+		}
+	}
+	if strings.HasPrefix(fileName, "/") || filepath.VolumeName(fileName) != "" {
+		return fmt.Errorf("el nombre debe ser una ruta relativa")
+	}
+	if path.Clean(fileName) != fileName {
+		return fmt.Errorf("el nombre no es una ruta normalizada")
+	}
+	for _, part := range strings.Split(fileName, "/") {
+		if part == ".." || part == "." {
+			return fmt.Errorf("el nombre sale de la raíz de almacenamiento")
+		}
+		// Los nombres con punto inicial quedan para las carpetas internas
+		// (.versions_*, .replicas_*, .proxy_cache_*), que el escaneo ignora.
+		if strings.HasPrefix(part, ".") {
+			return fmt.Errorf("'%s' es un nombre oculto reservado", part)
+		}
+		base := strings.ToLower(part)
+		if reservedNames[base] || deviceNames[strings.SplitN(base, ".", 2)[0]] {
+			return fmt.Errorf("'%s' es un nombre reservado", part)
+		}
+	}
+	return nil
+}
+
+// storagePath traduce un nombre lógico a su ruta dentro de la raíz de
+// almacenamiento. Además de validar el nombre, comprueba que ningún enlace
+// simbólico del camino lleve fuera de la raíz y que no apunte a una ruta
+// protegida del servidor.
+func storagePath(fileName string) (string, error) {
+	if err := validateFileName(fileName); err != nil {
+		return "", err
+	}
+	p := localPath(fileName)
+
+	root, err := filepath.Abs(shareDir)
+	if err != nil {
+		return "", err
+	}
+	if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+		root = realRoot
+	}
+	// Se resuelve la parte del camino que ya existe; el resto aún no se creó.
+	resolved, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	existing, rest := resolved, ""
+	for {
+		if realPath, err := filepath.EvalSymlinks(existing); err == nil {
+			resolved = filepath.Join(realPath, rest)
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("el nombre sale de la raíz de almacenamiento")
+	}
+
+	protectedPathsMutex.RLock()
+	defer protectedPathsMutex.RUnlock()
+	for _, protected := range protectedPaths {
+		if resolved == protected || strings.HasPrefix(resolved, protected+string(filepath.Separator)) {
+			return "", fmt.Errorf("el nombre corresponde a un archivo interno del servidor")
+		}
+	}
+	return p, nil
+}
+
+// invalidNameResponse devuelve el NACK para un nombre rechazado por
+// storagePath, o nil si el nombre es válido.
+func invalidNameResponse(fileName, localAddr string) *NetworkMessage {
+	if _, err := storagePath(fileName); err != nil {
+		logEvent("SERVER", "INVALID_NAME", fmt.Sprintf("Rechazado el nombre '%s': %v", fileName, err))
+		return &NetworkMessage{
+			Type:     "NACK",
+			Reason:   nackInvalidName,
+			Payload:  []byte(fmt.Sprintf("Nombre de archivo no permitido: %v.", err)),
+			SenderIP: localAddr,
+		}
+	}
+	return nil
+}
+
+// ensureStorageRoot crea la raíz de almacenamiento si no existe.
+func ensureStorageRoot() error {
+	if err := os.MkdirAll(shareDir, 0755); err != nil {
+		return fmt.Errorf("falla al crear la raíz de almacenamiento '%s': %v", shareDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFileName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"a.txt", true},
+		{"docs/a.txt", true},
+		{"docs/sub/informe final.doc", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../a.txt", false},
+		{"docs/../../a.txt", false},
+		{"docs/./a.txt", false},
+		{"docs//a.txt", false},
+		{"docs/", false},
+		{".", false},
+		{"docs\\a.txt", false},
+		{"a\x00.txt", false},
+		{"a\n.txt", false},
+		{".versions_8080/a.txt", false},
+		{"docs/.oculto", false},
+		{"server.key", false},
+		{"docs/CA.CRT", false},
+		{"nul.txt", false},
+		{"docs/COM1", false},
+		{strings.Repeat("a", maxFileNameLength+1), false},
+	}
+	for _, tt := range tests {
+		err := validateFileName(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateFileName(%q) = %v; válido esperado: %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+// TestPeerUpdatesValidateNames comprueba que las entradas que llegan de peers
+// con nombres inválidos no entran al directorio por ninguna vía.
+func TestPeerUpdatesValidateNames(t *testing.T) {
+	apply := map[string]func(DirectoryEntry){
+		"applyGossipUpdate":    applyGossipUpdate,
+		"applyOwnershipUpdate": applyOwnershipUpdate,
+	}
+	for name, fn := range apply {
+		for _, fileName := range []string{"../fuera.txt", "/etc/passwd", "server.key"} {
+			resetDirectory()
+			fn(DirectoryEntry{FileName: fileName, OwnerIP: "127.0.0.1:9001", Clock: VectorClock{"127.0.0.1:9001": 1}})
+			if _, found := sharedFiles[fileName]; found {
+				t.Errorf("%s aceptó el nombre inválido %q", name, fileName)
+			}
+		}
+		resetDirectory()
+		fn(DirectoryEntry{FileName: "docs/ok.txt", OwnerIP: "127.0.0.1:9001", Clock: VectorClock{"127.0.0.1:9001": 1}})
+		if _, found := sharedFiles["docs/ok.txt"]; !found {
+			t.Errorf("%s rechazó un nombre válido", name)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Detector de fallos al estilo SWIM. En cada periodo se sondea a un peer con
+// PING; si no contesta se pide a swimIndirectProbes peers que lo sondeen por
+// este nodo (PING_REQ). Sólo si nadie obtiene respuesta el peer pasa a
+// sospechoso, y sólo se le declara muerto si no refuta la sospecha antes de
+// suspicionTimeout. Así un paquete UDP perdido no expulsa a un peer sano.
+const (
+	swimProbeInterval    = 2 * time.Second
+	swimProbeTimeout     = 1500 * time.Millisecond
+	swimIndirectProbes   = 3
+	swimSuspicionTimeout = 15 * time.Second
+	swimDeadRetention    = 5 * time.Minute
+	swimMaxPiggyback     = 8
+)
+
+// Estados de un miembro en MemberInfo.Status.
+const (
+	memberAlive   = "alive"
+	memberSuspect = "suspect"
+	memberDead    = "dead"
+)
+
+// SwimMessage es el payload de PING, PING_REQ y de sus respuestas. Updates
+// lleva los cambios de estado recientes que se difunden "a caballo" de los sondeos.
+type SwimMessage struct {
+	Target  string       `json:"target,omitempty"` // Sólo en PING_REQ: peer a sondear.
+	Updates []MemberInfo `json:"updates,omitempty"`
+}
+
+// deadMember recuerda a un peer declarado muerto y con qué encarnación.
+type deadMember struct {
+	Incarnation uint64
+	Since       time.Time
+}
+
+// pendingUpdate es un cambio de estado y las veces que ya se difundió.
+type pendingUpdate struct {
+	info MemberInfo
+	sent int
+}
+
+// selfInfo describe a este nodo. Se llama con gp.mu tomado.
+func (gp *GossipProtocol) selfInfo() MemberInfo {
+	return MemberInfo{Addr: gp.selfAddr, Incarnation: gp.incarnation, Status: memberAlive}
+}
+
+// peerInfo describe a un peer de la lista según su estado local.
+func peerInfo(addr string, state PeerState) MemberInfo {
+	status := memberAlive
+	if state.Suspect {
+		status = memberSuspect
+	}
+	return MemberInfo{Addr: addr, Incarnation: state.Incarnation, Status: status}
+}
+
+// applyUpdate aplica un cambio de estado recibido (o generado localmente) con
+// las reglas de precedencia de SWIM: una encarnación mayor gana; con la misma
+// encarnación, sospechoso gana a vivo; muerto gana a todo. Si el cambio es
+// nuevo se encola para difundirlo. Se llama con gp.mu tomado en escritura.
+func (gp *GossipProtocol) applyUpdate(update MemberInfo, source string) {
+	if update.Addr == "" {
+		return
+	}
+	if update.Addr == gp.selfAddr {
+		// Otro nodo sospecha de este o lo dio por muerto: se refuta con una
+		// encarnación mayor.
+		if update.Status != memberAlive && update.Incarnation >= gp.incarnation {
+			gp.incarnation = update.Incarnation + 1
+			logEvent("HEARTBEAT", "REFUTE", fmt.Sprintf("%s informó a este nodo como %s. Nueva encarnación: %d", source, update.Status, gp.incarnation))
+			gp.enqueueUpdate(gp.selfInfo())
+		}
+		return
+	}
+
+	if record, dead := gp.dead[update.Addr]; dead {
+		if update.Status != memberAlive || update.Incarnation <= record.Incarnation {
+			return
+		}
+		delete(gp.dead, update.Addr)
+		logEvent("HEARTBEAT", "PEER_REJOINED", fmt.Sprintf("%s volvió con la encarnación %d.", update.Addr, update.Incarnation))
+	}
+
+	state, known := gp.Peers[update.Addr]
+	switch update.Status {
+	case memberDead:
+		if known {
+			delete(gp.Peers, update.Addr)
+			logEvent("HEARTBEAT", "PEER_DEAD", fmt.Sprintf("Peer %s considerado muerto (informado por %s). Eliminando de la lista.", update.Addr, source))
+			go failoverFilesOf(update.Addr)
+		}
+		if update.Incarnation < state.Incarnation {
+			update.Incarnation = state.Incarnation
+		}
+		gp.dead[update.Addr] = deadMember{Incarnation: update.Incarnation, Since: time.Now()}
+		gp.enqueueUpdate(update)
+
+	case memberSuspect:
+		if known && (update.Incarnation < state.Incarnation || (update.Incarnation == state.Incarnation && state.Suspect)) {
+			return
+		}
+		if !known {
+			logEvent("GOSSIP", "PEER_DISCOVERY", fmt.Sprintf("Nuevo peer descubierto: %s (informado por %s)", update.Addr, source))
+		}
+		if !state.Suspect {
+			state.SuspectSince = time.Now()
+			logEvent("HEARTBEAT", "PEER_SUSPECT", fmt.Sprintf("Peer %s sospechoso (informado por %s, encarnación %d).", update.Addr, source, update.Incarnation))
+		}
+		state.Suspect = true
+		state.Incarnation = update.Incarnation
+		gp.Peers[update.Addr] = state
+		gp.enqueueUpdate(update)
+
+	default:
+		if known && update.Incarnation <= state.Incarnation {
+			return
+		}
+		if !known {
+			state.LastSeen = time.Now()
+			logEvent("GOSSIP", "PEER_DISCOVERY", fmt.Sprintf("Nuevo peer descubierto: %s (informado por %s)", update.Addr, source))
+		} else if state.Suspect {
+			logEvent("HEARTBEAT", "PEER_ALIVE", fmt.Sprintf("Peer %s refutó la sospecha (encarnación %d).", update.Addr, update.Incarnation))
+		}
+		state.Suspect = false
+		state.Incarnation = update.Incarnation
+		gp.Peers[update.Addr] = state
+		update.Status = memberAlive
+		gp.enqueueUpdate(update)
+	}
+}
+
+// enqueueUpdate agrega un cambio a la cola de difusión, sustituyendo el que
+// hubiera para el mismo miembro. Se llama con gp.mu tomado en escritura.
+func (gp *GossipProtocol) enqueueUpdate(update MemberInfo) {
+	gp.updates[update.Addr] = &pendingUpdate{info: update}
+}
+
+// piggyback elige los cambios que viajarán en el próximo mensaje, empezando por
+// los menos difundidos. Cada cambio se envía unas 3·log2(n) veces, suficiente
+// para que llegue a todo el cluster con alta probabilidad.
+func (gp *GossipProtocol) piggyback() []MemberInfo {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	limit := 3 * int(math.Ceil(math.Log2(float64(len(gp.Peers)+2))))
+
+	pending := make([]*pendingUpdate, 0, len(gp.updates))
+	for _, update := range gp.updates {
+		pending = append(pending, update)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].sent < pending[j].sent })
+
+	updates := []MemberInfo{gp.selfInfo()}
+	for _, update := range pending {
+		if len(updates) >= swimMaxPiggyback {
+			break
+		}
+		updates = append(updates, update.info)
+		update.sent++
+		if update.sent >= limit {
+			delete(gp.updates, update.info.Addr)
+		}
+	}
+	return updates
+}
+
+// nextProbeTarget devuelve el siguiente peer a sondear. Los peers se recorren
+// en un orden aleatorio que se renueva en cada vuelta, de modo que todos se
+// sondean en un tiempo acotado.
+func (gp *GossipProtocol) nextProbeTarget() (string, bool) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	for len(gp.probeQueue) > 0 {
+		target := gp.probeQueue[0]
+		gp.probeQueue = gp.probeQueue[1:]
+		if _, alive := gp.Peers[target]; alive {
+			return target, true
+		}
+	}
+	for peer := range gp.Peers {
+		gp.probeQueue = append(gp.probeQueue, peer)
+	}
+	if len(gp.probeQueue) == 0 {
+		return "", false
+	}
+	rand.Shuffle(len(gp.probeQueue), func(i, j int) {
+		gp.probeQueue[i], gp.probeQueue[j] = gp.probeQueue[j], gp.probeQueue[i]
+	})
+	target := gp.probeQueue[0]
+	gp.probeQueue = gp.probeQueue[1:]
+	return target, true
+}
+
+// probeLoop ejecuta un sondeo por periodo y revisa las sospechas vencidas.
+func (gp *GossipProtocol) probeLoop() {
+	for {
+		time.Sleep(swimProbeInterval)
+		gp.Probe()
+		gp.CheckDeadPeers()
+	}
+}
+
+// Probe sondea al siguiente peer, primero directamente y después a través de
+// otros peers. Si ninguno obtiene respuesta, el peer pasa a sospechoso.
+func (gp *GossipProtocol) Probe() {
+	target, found := gp.nextProbeTarget()
+	if !found {
+		return
+	}
+	if gp.ping(target) {
+		return
+	}
+	if gp.indirectPing(target) {
+		logEvent("HEARTBEAT", "INDIRECT_ACK", fmt.Sprintf("%s no respondió directamente, pero sí a través de otros peers.", target))
+		return
+	}
+
+	gp.mu.Lock()
+	if state, known := gp.Peers[target]; known {
+		gp.applyUpdate(MemberInfo{Addr: target, Incarnation: state.Incarnation, Status: memberSuspect}, gp.selfAddr)
+	}
+	gp.mu.Unlock()
+}
+
+// ping envía PING a peerAddr y espera su ACK, aplicando los cambios que traiga.
+func (gp *GossipProtocol) ping(peerAddr string) bool {
+	payloadBytes, _ := json.Marshal(SwimMessage{Updates: gp.piggyback()})
+	response, err := gp.exchangeWithin(peerAddr, NetworkMessage{Type: "PING", Payload: payloadBytes, SenderIP: gp.selfAddr}, swimProbeTimeout)
+	if err != nil || response.Type != "ACK" {
+		return false
+	}
+	gp.applyPiggyback(response, peerAddr)
+	gp.mu.Lock()
+	if state, known := gp.Peers[peerAddr]; known {
+		state.LastSeen = time.Now()
+		gp.Peers[peerAddr] = state
+	}
+	gp.mu.Unlock()
+	return true
+}
+
+// indirectPing pide a swimIndirectProbes peers al azar que sondeen a target y
+// devuelve true si alguno recibió su ACK.
+func (gp *GossipProtocol) indirectPing(target string) bool {
+	var helpers []string
+	for _, peer := range gp.GetRandomPeers(swimIndirectProbes + 1) {
+		if peer != target && len(helpers) < swimIndirectProbes {
+			helpers = append(helpers, peer)
+		}
+	}
+	if len(helpers) == 0 {
+		return false
+	}
+
+	payloadBytes, _ := json.Marshal(SwimMessage{Target: target, Updates: gp.piggyback()})
+	msg := NetworkMessage{Type: "PING_REQ", Payload: payloadBytes, SenderIP: gp.selfAddr}
+	acks := make(chan bool, len(helpers))
+	var wg sync.WaitGroup
+	for _, helper := range helpers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			// El ayudante necesita su propio sondeo completo antes de contestar.
+			response, err := gp.exchangeWithin(addr, msg, 3*swimProbeTimeout)
+			if err != nil {
+				return
+			}
+			gp.applyPiggyback(response, addr)
+			acks <- response.Type == "ACK"
+		}(helper)
+	}
+	wg.Wait()
+	close(acks)
+	for ack := range acks {
+		if ack {
+			return true
+		}
+	}
+	return false
+}
+
+// exchangeWithin envía un mensaje y espera la respuesta sin pasar de timeout
+// entre el handshake y la lectura.
+func (gp *GossipProtocol) exchangeWithin(peerAddr string, msg NetworkMessage, timeout time.Duration) (NetworkMessage, error) {
+	deadline := time.Now().Add(timeout)
+	conn, err := gp.connectToPeerWithin(peerAddr, timeout)
+	if err != nil {
+		return NetworkMessage{}, err
+	}
+	defer conn.Close()
+	if err := writeMessage(conn, msg); err != nil {
+		return NetworkMessage{}, fmt.Errorf("falla al enviar %s a %s: %v", msg.Type, peerAddr, err)
+	}
+	setReadDeadline(conn, deadline)
+	return readMessage(conn)
+}
+
+// applyPiggyback aplica los cambios de estado que trae un mensaje de SWIM.
+func (gp *GossipProtocol) applyPiggyback(msg NetworkMessage, source string) {
+	var swimMsg SwimMessage
+	if err := json.Unmarshal(msg.Payload, &swimMsg); err != nil {
+		return
+	}
+	gp.MergeMembers(swimMsg.Updates, source)
+}
+
+// swimReply arma la respuesta a un sondeo con los cambios pendientes. Si quien
+// sondea figura como muerto se le incluye esa baja para que la refute.
+func (gp *GossipProtocol) swimReply(msgType, sender, localAddr string) NetworkMessage {
+	updates := gp.piggyback()
+	gp.mu.RLock()
+	if record, dead := gp.dead[sender]; dead {
+		updates = append(updates, MemberInfo{Addr: sender, Incarnation: record.Incarnation, Status: memberDead})
+	}
+	gp.mu.RUnlock()
+	payloadBytes, _ := json.Marshal(SwimMessage{Updates: updates})
+	return NetworkMessage{
+		Type:          msgType,
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// HandlePing atiende PING: aplica los cambios recibidos y responde ACK.
+func (gp *GossipProtocol) HandlePing(msg NetworkMessage, localAddr string) NetworkMessage {
+	gp.applyPiggyback(msg, msg.SenderIP)
+	return gp.swimReply("ACK", msg.SenderIP, localAddr)
+}
+
+// HandlePingReq atiende PING_REQ: sondea al peer indicado en nombre de quien lo
+// pide y responde ACK si obtuvo respuesta, o NACK si no.
+func (gp *GossipProtocol) HandlePingReq(msg NetworkMessage, localAddr string) NetworkMessage {
+	var swimMsg SwimMessage
+	json.Unmarshal(msg.Payload, &swimMsg)
+	gp.MergeMembers(swimMsg.Updates, msg.SenderIP)
+	if swimMsg.Target == "" || !gp.ping(swimMsg.Target) {
+		return gp.swimReply("NACK", msg.SenderIP, localAddr)
+	}
+	return gp.swimReply("ACK", msg.SenderIP, localAddr)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// mergeEntry aplica una entrada recibida de otro nodo si su reloj vectorial
+// desciende del local. Ante relojes iguales gana la lápida, para que un archivo
+// eliminado no resucite con la siguiente ronda de anti-entropía; las versiones
+// concurrentes se resuelven con resolveSiblings. Debe llamarse con
+// sharedFilesMutex tomado en escritura. Devuelve true si la entrada cambió algo.
+func mergeEntry(incoming DirectoryEntry) bool {
+	if err := validateFileName(incoming.FileName); err != nil {
+		logEvent("SERVER", "INVALID_NAME", fmt.Sprintf("Ignorada entrada de peer con nombre '%s': %v", incoming.FileName, err))
+		return false
+	}
+	existing, found := sharedFiles[incoming.FileName]
+	if found {
+		switch entryClock(incoming).Compare(entryClock(existing)) {
+		case clockBefore:
+			return false
+		case clockEqual:
+			if existing.Deleted || !incoming.Deleted {
+				return false
+			}
+		case clockConcurrent:
+			resolveSiblings(existing, incoming)
+			return true
+		}
+	}
+	sharedFiles[incoming.FileName] = incoming
+	dirStore.Put(incoming)
+	return true
+}
+
+// handleDeleteFile atiende DELETE_FILE. Sólo el dueño acepta la petición: borra
+// el archivo del disco y publica una lápida con una versión mayor.
+func handleDeleteFile(msg NetworkMessage, localAddr string) NetworkMessage {
+	var fileName string
+	json.Unmarshal(msg.Payload, &fileName)
+	logEvent("SERVER", "DELETE_REQUEST", fmt.Sprintf("Petición para eliminar el archivo '%s'.", fileName))
+	if nack := invalidNameResponse(fileName, localAddr); nack != nil {
+		return *nack
+	}
+
+	sharedFilesMutex.Lock()
+	entry, found := sharedFiles[fileName]
+	if !found || entry.Deleted {
+		sharedFilesMutex.Unlock()
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte("Archivo no encontrado en el directorio."),
+			Authoritative: false,
+			SenderIP:      localAddr,
+		}
+	}
+	if entry.OwnerIP != selfAddr {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "REDIRECT", fmt.Sprintf("Sólo el dueño puede eliminar '%s'. Redireccionando a %s.", fileName, entry.OwnerIP))
+		return NetworkMessage{
+			Type:    "REDIRECT_OWNER",
+			Payload: []byte(entry.OwnerIP),
+		}
+	}
+	if entry.IsDir && hasLiveChildren(fileName) {
+		sharedFilesMutex.Unlock()
+		return namespaceNack(localAddr, "La carpeta '%s' no está vacía.", fileName)
+	}
+	if reason, locked := lockConflict(fileName, ""); locked {
+		sharedFilesMutex.Unlock()
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Eliminación rechazada: " + reason),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+	if err := os.Remove(localPath(fileName)); err != nil && !os.IsNotExist(err) {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al eliminar el archivo '%s': %v", fileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al eliminar el archivo."),
+		}
+	}
+	entry.Deleted = true
+	entry.Unavailable = false
+	entry.Size = 0
+	bumpVersion(&entry, selfAddr)
+	entry.ModificationDate = time.Now()
+	sharedFiles[fileName] = entry
+	dirStore.Put(entry)
+	sharedFilesMutex.Unlock()
+
+	logEvent("SERVER", "FILE_DELETED", fmt.Sprintf("Archivo '%s' eliminado. Lápida versión %d.", fileName, entry.Version))
+	go gossipProtocol.GossipUpdateAllPeers(entry)
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte("Archivo eliminado."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// collectTombstones elimina definitivamente las lápidas más antiguas que el
+// periodo de gracia. Para entonces se asume que todos los peers ya la recibieron.
+func collectTombstones(grace time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		sharedFilesMutex.Lock()
+		for name, entry := range sharedFiles {
+			if entry.Deleted && time.Since(entry.ModificationDate) > grace {
+				delete(sharedFiles, name)
+				dirStore.Delete(name)
+				versions.Forget(name)
+				replicas.Forget(name)
+				proxyCache.Forget(name)
+				logEvent("SERVER", "TOMBSTONE_GC", fmt.Sprintf("Lápida de '%s' (versión %d) eliminada tras el periodo de gracia.", name, entry.Version))
+			}
+		}
+		sharedFilesMutex.Unlock()
+	}
+}
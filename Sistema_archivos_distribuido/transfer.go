@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChunkLength = 32 * 1024
+	maxChunkLength     = 256 * 1024
+)
+
+// FileChunkRequest pide un rango de bytes de un archivo.
+type FileChunkRequest struct {
+	FileName string `json:"file_name"`
+	Offset   int64  `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// FileChunk es la respuesta a REQUEST_FILE_CHUNK. Incluye el SHA-256 del
+// fragmento y del archivo completo para que el cliente detecte corrupción y
+// pueda saber si el archivo cambió entre sesiones.
+type FileChunk struct {
+	FileName    string `json:"file_name"`
+	Offset      int64  `json:"offset"`
+	Data        []byte `json:"data"`
+	ChunkSHA256 string `json:"chunk_sha256"`
+	FileSize    int64  `json:"file_size"`
+	FileSHA256  string `json:"file_sha256"`
+	Version     int    `json:"version"`
+	EOF         bool   `json:"eof"`
+}
+
+type fileHashCacheEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	fileHashCacheMutex sync.Mutex
+	fileHashCache      = make(map[string]fileHashCacheEntry)
+)
+
+// fileSHA256 calcula el hash del archivo completo, reutilizando el último
+// resultado mientras el tamaño y la fecha de modificación no cambien.
+func fileSHA256(path string) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fileHashCacheMutex.Lock()
+	cached, ok := fileHashCache[path]
+	fileHashCacheMutex.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, cached.size, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", 0, err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	fileHashCacheMutex.Lock()
+	fileHashCache[path] = fileHashCacheEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	fileHashCacheMutex.Unlock()
+	return sum, info.Size(), nil
+}
+
+// handleFileChunkRequest atiende REQUEST_FILE_CHUNK sirviendo un rango de bytes
+// del archivo si este servidor es el dueño o guarda una réplica de la versión actual.
+// Sólo a los clientes (no a otros servidores) se les sirve por proxy.
+func handleFileChunkRequest(msg NetworkMessage, localAddr string, id Identity) NetworkMessage {
+	var req FileChunkRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		return NetworkMessage{
+			Type:     "NACK",
+			Payload:  []byte("Petición de fragmento malformada."),
+			SenderIP: localAddr,
+		}
+	}
+	if nack := invalidNameResponse(req.FileName, localAddr); nack != nil {
+		return *nack
+	}
+	if req.Length <= 0 || req.Length > maxChunkLength {
+		req.Length = defaultChunkLength
+	}
+
+	sharedFilesMutex.RLock()
+	entry, found := sharedFiles[req.FileName]
+	sharedFilesMutex.RUnlock()
+	if !found || entry.Deleted {
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte("Archivo no encontrado en el directorio."),
+			Authoritative: false,
+			SenderIP:      localAddr,
+		}
+	}
+	path, servable := servingPath(entry, !id.Server)
+	if !servable {
+		logEvent("SERVER", "REDIRECT", fmt.Sprintf("Redireccionando fragmento de '%s' a %s.", req.FileName, entry.OwnerIP))
+		return NetworkMessage{
+			Type:    "REDIRECT_OWNER",
+			Payload: []byte(entry.OwnerIP),
+		}
+	}
+
+	if entry.OwnerIP == selfAddr && entry.Unavailable {
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte("El archivo no está disponible en el servidor dueño."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	fileSum, fileSize, err := fileSHA256(path)
+	if err != nil {
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al calcular el hash de '%s': %v", req.FileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al leer el archivo."),
+		}
+	}
+	if req.Offset < 0 || req.Offset > fileSize {
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte(fmt.Sprintf("Desplazamiento %d fuera de rango (tamaño %d).", req.Offset, fileSize)),
+		}
+	}
+
+	if req.Offset == 0 && entry.OwnerIP == selfAddr {
+		// El cliente podría editar esta versión: se guarda como base de fusión.
+		recordCurrentVersion(req.FileName, entry)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al abrir el archivo '%s': %v", req.FileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al leer el archivo."),
+		}
+	}
+	defer file.Close()
+
+	data := make([]byte, req.Length)
+	n, err := file.ReadAt(data, req.Offset)
+	if err != nil && err != io.EOF {
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al leer el fragmento de '%s' en %d: %v", req.FileName, req.Offset, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al leer el archivo."),
+		}
+	}
+	data = data[:n]
+	chunkSum := sha256.Sum256(data)
+
+	chunk := FileChunk{
+		FileName:    req.FileName,
+		Offset:      req.Offset,
+		Data:        data,
+		ChunkSHA256: hex.EncodeToString(chunkSum[:]),
+		FileSize:    fileSize,
+		FileSHA256:  fileSum,
+		Version:     entry.Version,
+		EOF:         req.Offset+int64(n) >= fileSize,
+	}
+	payloadBytes, _ := json.Marshal(chunk)
+	logEvent("SERVER", "FILE_CHUNK_SENT", fmt.Sprintf("Fragmento de '%s' [%d, %d) de %d bytes enviado.", req.FileName, req.Offset, req.Offset+int64(n), fileSize))
+	return NetworkMessage{
+		Type:          "FILE_CHUNK",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// handleFileWriteUpdate atiende FILE_WRITE_UPDATE. El reloj que trae la
+// actualización es el de la versión que el cliente descargó: si coincide con
+// el actual, los cambios se aplican; si no, la edición fue concurrente con otra
+// y se intenta una fusión a tres bandas contra esa versión base. Si la fusión
+// tiene conflictos se devuelven al cliente con marcadores para que los resuelva;
+// si no es posible (archivo binario o base desconocida) los cambios se
+// conservan como copia en conflicto en lugar de rechazarlos. Sólo el dueño
+// aplica actualizaciones: los demás nodos las reenvían (ver forwardFileWriteUpdate).
+// Origin y SenderIP sólo se creen si la sesión es de otro servidor (id.Server):
+// un cliente no puede elegir a qué nodo se atribuye su edición ni hacerla pasar
+// por reenviada.
+func handleFileWriteUpdate(msg NetworkMessage, localAddr, remoteAddr string, id Identity) NetworkMessage {
+	var fileUpdate FileUpdate
+	json.Unmarshal(msg.Payload, &fileUpdate)
+	logEvent("SERVER", "FILE_WRITE_UPDATE", fmt.Sprintf("Recibida actualización para '%s' desde %s.", fileUpdate.FileName, remoteAddr))
+	if nack := invalidNameResponse(fileUpdate.FileName, localAddr); nack != nil {
+		return *nack
+	}
+
+	// origin es el nodo al que se atribuye la modificación en el reloj vectorial:
+	// el que recibió la edición del cliente.
+	origin := selfAddr
+	if id.Server && fileUpdate.Origin != "" {
+		origin = fileUpdate.Origin
+	}
+	forwarded := id.Server && msg.SenderIP != ""
+
+	sharedFilesMutex.Lock()
+	entry, found := sharedFiles[fileUpdate.FileName]
+	if !found || entry.Deleted {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "UPDATE_REJECTED", fmt.Sprintf("Rechazada actualización de '%s': el archivo no existe.", fileUpdate.FileName))
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Actualización rechazada: el archivo ya no existe en el directorio."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+	if entry.IsDir {
+		sharedFilesMutex.Unlock()
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Actualización rechazada: '" + fileUpdate.FileName + "' es una carpeta."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	if entry.OwnerIP != selfAddr {
+		sharedFilesMutex.Unlock()
+		if forwarded {
+			// Ya viene reenviada por otro servidor con un directorio desactualizado:
+			// no se reenvía de nuevo, se indica quién es el dueño.
+			logEvent("SERVER", "REDIRECT", fmt.Sprintf("Actualización reenviada de '%s' desde %s: el dueño es %s.", fileUpdate.FileName, msg.SenderIP, entry.OwnerIP))
+			return NetworkMessage{
+				Type:    "REDIRECT_OWNER",
+				Payload: []byte(entry.OwnerIP),
+			}
+		}
+		fileUpdate.Origin = origin
+		return forwardFileWriteUpdate(fileUpdate, entry)
+	}
+
+	if reason, locked := lockConflict(fileUpdate.FileName, fileUpdate.LockToken); locked {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "UPDATE_REJECTED", fmt.Sprintf("Rechazada actualización de '%s' desde %s: %s", fileUpdate.FileName, remoteAddr, reason))
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Actualización rechazada: " + reason),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	current := entryClock(entry)
+	base := VectorClock(fileUpdate.Clock)
+	if len(base) == 0 {
+		// Cliente sin reloj vectorial: su Version se atribuye al dueño.
+		base = entryClock(DirectoryEntry{OwnerIP: entry.OwnerIP, Version: fileUpdate.Version})
+	}
+
+	if base.Compare(current) == clockEqual {
+		if err := os.WriteFile(localPath(fileUpdate.FileName), fileUpdate.Content, 0644); err != nil {
+			sharedFilesMutex.Unlock()
+			logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al escribir en el archivo '%s': %v", fileUpdate.FileName, err))
+			return NetworkMessage{
+				Type:    "NACK",
+				Payload: []byte("Error al escribir el archivo."),
+			}
+		}
+		bumpVersion(&entry, origin)
+		entry.Size = int64(len(fileUpdate.Content))
+		entry.ModificationDate = fileModTime(fileUpdate.FileName)
+		entry.Unavailable = false
+		assignReplicas(&entry)
+		sharedFiles[fileUpdate.FileName] = entry
+		dirStore.Put(entry)
+		versions.Record(fileUpdate.FileName, entry, fileUpdate.Content)
+		sharedFilesMutex.Unlock()
+		go gossipProtocol.GossipUpdateAllPeers(entry)
+		go replicateFile(entry, fileUpdate.Content)
+		logEvent("SERVER", "UPDATE_SUCCESS", fmt.Sprintf("Archivo '%s' actualizado con éxito. Nueva versión: %d", fileUpdate.FileName, entry.Version))
+		return NetworkMessage{
+			Type:          "UPDATE_ACK",
+			Payload:       []byte("Archivo actualizado con éxito."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	if response, handled := mergeFileWriteUpdate(fileUpdate, entry, base, origin, localAddr); handled {
+		sharedFilesMutex.Unlock()
+		return response
+	}
+
+	copyName := conflictCopyName(fileUpdate.FileName, origin)
+	logEvent("SERVER", "COLLISION_DETECTED", fmt.Sprintf("Edición concurrente de '%s' (base %v, actual %v). Se guarda como '%s'.", fileUpdate.FileName, base, current, copyName))
+	if err := os.WriteFile(localPath(copyName), fileUpdate.Content, 0644); err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al escribir la copia en conflicto '%s': %v", copyName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al escribir el archivo."),
+		}
+	}
+	copyEntry := DirectoryEntry{
+		FileName:         copyName,
+		Extension:        filepath.Ext(fileUpdate.FileName),
+		Size:             int64(len(fileUpdate.Content)),
+		ModificationDate: fileModTime(copyName),
+		Clock:            base,
+		TTL:              entry.TTL,
+		OwnerIP:          selfAddr,
+		ACL:              entry.ACL,
+	}
+	bumpVersion(&copyEntry, origin)
+	assignReplicas(&copyEntry)
+	sharedFiles[copyName] = copyEntry
+	dirStore.Put(copyEntry)
+	sharedFilesMutex.Unlock()
+	go gossipProtocol.GossipUpdateAllPeers(copyEntry)
+	go replicateFile(copyEntry, fileUpdate.Content)
+
+	return NetworkMessage{
+		Type:          "UPDATE_CONFLICT_COPY",
+		Payload:       []byte(fmt.Sprintf("Otra edición llegó antes. Tus cambios se guardaron como '%s'.", copyName)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// forwardFileWriteUpdate envía al dueño una actualización recibida por un nodo
+// que no lo es y devuelve su veredicto tal cual, para que el cliente vea el
+// mismo resultado que si hubiera hablado con el dueño. Se prueba primero con el
+// nodo del que salió la copia local (la unidad de trabajo) y después con el
+// dueño que indica el directorio, si son distintos.
+func forwardFileWriteUpdate(fileUpdate FileUpdate, entry DirectoryEntry) NetworkMessage {
+	var targets []string
+	localWorkUnitsMutex.RLock()
+	if workOwner, found := localWorkUnits[fileUpdate.FileName]; found {
+		targets = append(targets, workOwner)
+	}
+	localWorkUnitsMutex.RUnlock()
+	if len(targets) == 0 || targets[0] != entry.OwnerIP {
+		targets = append(targets, entry.OwnerIP)
+	}
+
+	payloadBytes, _ := json.Marshal(fileUpdate)
+	msg := NetworkMessage{Type: "FILE_WRITE_UPDATE", Payload: payloadBytes, SenderIP: selfAddr}
+	response := NetworkMessage{
+		Type:    "NACK",
+		Payload: []byte("No se pudo contactar al dueño del archivo para aplicar la actualización."),
+	}
+	for _, owner := range targets {
+		logEvent("SERVER", "UPDATE_FORWARD", fmt.Sprintf("'%s' pertenece a %s. Reenviando la actualización al dueño.", fileUpdate.FileName, owner))
+		ownerResponse, err := gossipProtocol.Forward(owner, msg)
+		if err != nil {
+			logEvent("SERVER", "ERROR", err.Error())
+			continue
+		}
+		logEvent("SERVER", "UPDATE_FORWARDED", fmt.Sprintf("%s respondió %s para '%s'.", owner, ownerResponse.Type, fileUpdate.FileName))
+		response = ownerResponse
+		if response.Type != "REDIRECT_OWNER" {
+			break
+		}
+	}
+	if response.Authoritative {
+		// El dueño resolvió la actualización: la copia local ya no es la vigente.
+		localWorkUnitsMutex.Lock()
+		delete(localWorkUnits, fileUpdate.FileName)
+		localWorkUnitsMutex.Unlock()
+	}
+	return response
+}
+
+// mergeFileWriteUpdate fusiona una edición concurrente con la versión actual
+// usando como base la versión que descargó el cliente. Devuelve handled=false si
+// la fusión no es posible y debe recurrirse a la copia en conflicto. Debe
+// llamarse con sharedFilesMutex tomado en escritura.
+func mergeFileWriteUpdate(fileUpdate FileUpdate, entry DirectoryEntry, base VectorClock, origin, localAddr string) (NetworkMessage, bool) {
+	baseContent, found := versions.Lookup(fileUpdate.FileName, base)
+	if !found {
+		logEvent("SERVER", "MERGE_SKIPPED", fmt.Sprintf("No se conserva la versión base %v de '%s'.", base, fileUpdate.FileName))
+		return NetworkMessage{}, false
+	}
+	currentContent, err := os.ReadFile(localPath(fileUpdate.FileName))
+	if err != nil {
+		return NetworkMessage{}, false
+	}
+	merged, conflict, ok := mergeThreeWay(baseContent, currentContent, fileUpdate.Content)
+	if !ok {
+		logEvent("SERVER", "MERGE_SKIPPED", fmt.Sprintf("'%s' no es un archivo de texto fusionable.", fileUpdate.FileName))
+		return NetworkMessage{}, false
+	}
+
+	if conflict {
+		logEvent("SERVER", "MERGE_CONFLICT", fmt.Sprintf("La fusión de '%s' tiene conflictos. Se devuelven al cliente para resolverlos.", fileUpdate.FileName))
+		payloadBytes, _ := json.Marshal(MergeConflict{
+			FileName: fileUpdate.FileName,
+			Content:  merged,
+			Version:  entry.Version,
+			Clock:    entryClock(entry),
+		})
+		return NetworkMessage{
+			Type:          "UPDATE_CONFLICT",
+			Payload:       payloadBytes,
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}, true
+	}
+
+	if err := os.WriteFile(localPath(fileUpdate.FileName), merged, 0644); err != nil {
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al escribir la fusión de '%s': %v", fileUpdate.FileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al escribir el archivo."),
+		}, true
+	}
+	// La versión fusionada desciende de la actual y de la base del cliente.
+	bumpVersion(&entry, origin)
+	entry.Size = int64(len(merged))
+	entry.ModificationDate = fileModTime(fileUpdate.FileName)
+	entry.Unavailable = false
+	assignReplicas(&entry)
+	sharedFiles[fileUpdate.FileName] = entry
+	dirStore.Put(entry)
+	versions.Record(fileUpdate.FileName, entry, merged)
+	logEvent("SERVER", "UPDATE_MERGED", fmt.Sprintf("Edición concurrente de '%s' fusionada. Nueva versión: %d", fileUpdate.FileName, entry.Version))
+	go gossipProtocol.GossipUpdateAllPeers(entry)
+	go replicateFile(entry, merged)
+	return NetworkMessage{
+		Type:          "UPDATE_MERGED",
+		Payload:       []byte("Otra edición llegó antes; tus cambios se fusionaron con ella."),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}, true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// VectorClock cuenta, por nodo, cuántas modificaciones de un archivo originó
+// cada uno. Permite distinguir una versión que desciende de otra de dos
+// versiones concurrentes, sin depender de los relojes de pared de cada máquina.
+type VectorClock map[string]int64
+
+type clockOrder int
+
+const (
+	clockEqual clockOrder = iota
+	clockBefore
+	clockAfter
+	clockConcurrent
+)
+
+// Copy devuelve una copia independiente del reloj.
+func (vc VectorClock) Copy() VectorClock {
+	out := make(VectorClock, len(vc))
+	for node, counter := range vc {
+		out[node] = counter
+	}
+	return out
+}
+
+// Increment devuelve una copia del reloj con el contador de node aumentado.
+func (vc VectorClock) Increment(node string) VectorClock {
+	out := vc.Copy()
+	out[node]++
+	return out
+}
+
+// Sum es el total de modificaciones; se usa como número de versión legible.
+func (vc VectorClock) Sum() int64 {
+	var total int64
+	for _, counter := range vc {
+		total += counter
+	}
+	return total
+}
+
+// String devuelve el reloj como "nodo:contador" ordenado por nodo, igual en
+// todos los nodos.
+func (vc VectorClock) String() string {
+	nodes := make([]string, 0, len(vc))
+	for node, counter := range vc {
+		if counter > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = fmt.Sprintf("%s:%d", node, vc[node])
+	}
+	return strings.Join(parts, ",")
+}
+
+// Compare indica si vc es anterior, posterior, igual o concurrente a other.
+func (vc VectorClock) Compare(other VectorClock) clockOrder {
+	less, greater := false, false
+	for node, counter := range vc {
+		if counter > other[node] {
+			greater = true
+		} else if counter < other[node] {
+			less = true
+		}
+	}
+	for node, counter := range other {
+		if _, ok := vc[node]; !ok && counter > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return clockConcurrent
+	case less:
+		return clockBefore
+	case greater:
+		return clockAfter
+	}
+	return clockEqual
+}
+
+// entryClock devuelve el reloj de una entrada. Las entradas anteriores a los
+// relojes vectoriales sólo tienen Version, que se atribuye a su dueño.
+func entryClock(entry DirectoryEntry) VectorClock {
+	if len(entry.Clock) > 0 {
+		return entry.Clock
+	}
+	if entry.Version > 0 {
+		return VectorClock{entry.OwnerIP: int64(entry.Version)}
+	}
+	return VectorClock{}
+}
+
+// bumpVersion registra una modificación originada en node.
+func bumpVersion(entry *DirectoryEntry, node string) {
+	entry.Clock = entryClock(*entry).Increment(node)
+	entry.Version = int(entry.Clock.Sum())
+}
+
+// conflictCopyName elige un nombre libre para la copia en conflicto de fileName.
+// Debe llamarse con sharedFilesMutex tomado.
+func conflictCopyName(fileName, origin string) string {
+	name := fmt.Sprintf("%s (conflict from %s)", fileName, origin)
+	for i := 2; ; i++ {
+		if _, taken := sharedFiles[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s (conflict from %s %d)", fileName, origin, i)
+	}
+}
+
+// resolveSiblings resuelve dos entradas concurrentes del mismo archivo de forma
+// determinista, para que todos los nodos converjan al mismo resultado: gana la
+// entrada viva sobre la lápida, luego la del dueño con dirección menor, luego
+// la de más modificaciones y, si aún empatan, la de reloj mayor como texto. La
+// perdedora se conserva como copia en conflicto; si este nodo es su
+// dueño, además renombra el archivo en disco. Debe llamarse con
+// sharedFilesMutex tomado en escritura.
+func resolveSiblings(existing, incoming DirectoryEntry) {
+	winner, loser := existing, incoming
+	switch {
+	case existing.Deleted != incoming.Deleted:
+		if existing.Deleted {
+			winner, loser = incoming, existing
+		}
+	case existing.OwnerIP != incoming.OwnerIP:
+		if incoming.OwnerIP < existing.OwnerIP {
+			winner, loser = incoming, existing
+		}
+	case entryClock(incoming).Sum() != entryClock(existing).Sum():
+		if entryClock(incoming).Sum() > entryClock(existing).Sum() {
+			winner, loser = incoming, existing
+		}
+	case entryClock(incoming).String() > entryClock(existing).String():
+		// Dos relojes concurrentes nunca son iguales, así que este orden es
+		// total y no depende de cuál de las dos llegó primero.
+		winner, loser = incoming, existing
+	}
+
+	name := winner.FileName
+	sharedFiles[name] = winner
+	dirStore.Put(winner)
+	logEvent("SERVER", "CONFLICT_DETECTED", fmt.Sprintf("Versiones concurrentes de '%s' (dueños %s y %s). Se conserva la de %s.", name, existing.OwnerIP, incoming.OwnerIP, winner.OwnerIP))
+
+	// Una lápida perdedora o una versión del mismo dueño no tienen contenido
+	// propio que conservar.
+	if loser.Deleted || loser.OwnerIP == winner.OwnerIP {
+		return
+	}
+	copyName := fmt.Sprintf("%s (conflict from %s)", name, loser.OwnerIP)
+	if current, found := sharedFiles[copyName]; found && entryClock(current).Compare(entryClock(loser)) != clockBefore {
+		return
+	}
+	loser.FileName = copyName
+	if loser.OwnerIP == selfAddr {
+		if err := os.Rename(localPath(name), localPath(copyName)); err != nil && !os.IsNotExist(err) {
+			logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al renombrar '%s' como copia en conflicto: %v", name, err))
+		}
+	}
+	sharedFiles[copyName] = loser
+	dirStore.Put(loser)
+	logEvent("SERVER", "CONFLICT_COPY", fmt.Sprintf("La versión de %s se conserva como '%s'.", loser.OwnerIP, copyName))
+}
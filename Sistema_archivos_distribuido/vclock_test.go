@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestVectorClockCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b VectorClock
+		want clockOrder
+	}{
+		{"vacíos", VectorClock{}, VectorClock{}, clockEqual},
+		{"ceros equivalen a ausentes", VectorClock{"a": 0}, VectorClock{}, clockEqual},
+		{"iguales", VectorClock{"a": 1, "b": 2}, VectorClock{"a": 1, "b": 2}, clockEqual},
+		{"anterior", VectorClock{"a": 1}, VectorClock{"a": 2}, clockBefore},
+		{"anterior por nodo nuevo", VectorClock{"a": 1}, VectorClock{"a": 1, "b": 1}, clockBefore},
+		{"posterior", VectorClock{"a": 2, "b": 1}, VectorClock{"a": 1}, clockAfter},
+		{"concurrentes", VectorClock{"a": 2}, VectorClock{"a": 1, "b": 1}, clockConcurrent},
+		{"concurrentes disjuntos", VectorClock{"a": 1}, VectorClock{"b": 1}, clockConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Compare(tt.b); got != tt.want {
+				t.Errorf("Compare = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVectorClockString(t *testing.T) {
+	vc := VectorClock{"b": 2, "a": 1, "c": 0}
+	if got, want := vc.String(), "a:1,b:2"; got != want {
+		t.Errorf("String = %q, se esperaba %q", got, want)
+	}
+}
+
+// TestResolveSiblingsConverges comprueba que dos nodos que reciben las mismas
+// entradas concurrentes en orden inverso se quedan con la misma.
+func TestResolveSiblingsConverges(t *testing.T) {
+	const owner = "127.0.0.1:9001" // Ajeno, para no tocar archivos en disco.
+	tests := []struct {
+		name string
+		a, b DirectoryEntry
+		want VectorClock
+	}{
+		{
+			"viva sobre lápida",
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"x": 2}},
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"y": 1}, Deleted: true},
+			VectorClock{"x": 2},
+		},
+		{
+			"dueño menor",
+			DirectoryEntry{OwnerIP: "127.0.0.1:9002", Clock: VectorClock{"x": 1}},
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"y": 1}},
+			VectorClock{"y": 1},
+		},
+		{
+			"más modificaciones",
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"x": 1}},
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"y": 2}},
+			VectorClock{"y": 2},
+		},
+		{
+			"mismo dueño y misma suma",
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"x": 1, "z": 1}},
+			DirectoryEntry{OwnerIP: owner, Clock: VectorClock{"y": 2}},
+			VectorClock{"y": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.a.FileName, tt.b.FileName = "f.txt", "f.txt"
+			for _, order := range [][2]DirectoryEntry{{tt.a, tt.b}, {tt.b, tt.a}} {
+				resetDirectory(order[0])
+				resolveSiblings(order[0], order[1])
+				if got := sharedFiles["f.txt"].Clock; got.Compare(tt.want) != clockEqual {
+					t.Errorf("con %v primero gana %v, se esperaba %v", order[0].Clock, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// VersionRequest identifica una versión concreta de un archivo (GET_VERSION y ROLLBACK).
+type VersionRequest struct {
+	FileName string `json:"file_name"`
+	Version  int    `json:"version"`
+}
+
+// versionRecord describe una versión guardada de un archivo propio.
+type versionRecord struct {
+	Version          int         `json:"version"`
+	Clock            VectorClock `json:"clock"`
+	Hash             string      `json:"hash"`
+	Size             int64       `json:"size"`
+	ModificationDate time.Time   `json:"modification_date"`
+}
+
+// versionStore guarda el contenido de las últimas versiones de cada archivo
+// propio en un almacén de objetos direccionado por SHA-256. Sirve de base para
+// las fusiones a tres bandas y para consultar o revertir versiones anteriores.
+type versionStore struct {
+	mu      sync.Mutex
+	dir     string
+	limit   int
+	history map[string][]versionRecord // De la más antigua a la más reciente.
+}
+
+var versions *versionStore
+
+// openVersionStore crea (si hace falta) el directorio de objetos y carga el índice.
+func openVersionStore(dir string, limit int) (*versionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("falla al crear el almacén de versiones '%s': %v", dir, err)
+	}
+	vs := &versionStore{dir: dir, limit: limit, history: make(map[string][]versionRecord)}
+	data, err := os.ReadFile(vs.indexPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &vs.history); err != nil {
+			return nil, fmt.Errorf("índice de versiones corrupto: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("falla al leer el índice de versiones: %v", err)
+	}
+	return vs, nil
+}
+
+func (vs *versionStore) indexPath() string {
+	return filepath.Join(vs.dir, "history.json")
+}
+
+func (vs *versionStore) objectPath(hash string) string {
+	return filepath.Join(vs.dir, hash)
+}
+
+// saveIndex reescribe el índice de forma atómica. Se llama con vs.mu tomado.
+func (vs *versionStore) saveIndex() error {
+	data, err := json.Marshal(vs.history)
+	if err != nil {
+		return err
+	}
+	tmpPath := vs.indexPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, vs.indexPath())
+}
+
+// Record guarda el contenido de la versión actual de un archivo si aún no está.
+func (vs *versionStore) Record(fileName string, entry DirectoryEntry, content []byte) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	clock := entryClock(entry)
+	records := vs.history[fileName]
+	if n := len(records); n > 0 && records[n-1].Clock.Compare(clock) == clockEqual {
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if _, err := os.Stat(vs.objectPath(hash)); os.IsNotExist(err) {
+		if err := os.WriteFile(vs.objectPath(hash), content, 0644); err != nil {
+			logEvent("VERSIONS", "ERROR", fmt.Sprintf("Falla al guardar la versión %d de '%s': %v", entry.Version, fileName, err))
+			return
+		}
+	}
+	records = append(records, versionRecord{
+		Version:          entry.Version,
+		Clock:            clock.Copy(),
+		Hash:             hash,
+		Size:             int64(len(content)),
+		ModificationDate: entry.ModificationDate,
+	})
+	var dropped []versionRecord
+	if len(records) > vs.limit {
+		dropped = records[:len(records)-vs.limit]
+		records = append([]versionRecord(nil), records[len(records)-vs.limit:]...)
+	}
+	vs.history[fileName] = records
+	for _, old := range dropped {
+		vs.removeIfUnreferenced(old.Hash)
+	}
+	if err := vs.saveIndex(); err != nil {
+		logEvent("VERSIONS", "ERROR", fmt.Sprintf("Falla al guardar el índice de versiones: %v", err))
+	}
+}
+
+// removeIfUnreferenced borra un objeto que ya no pertenece a ninguna versión.
+// Se llama con vs.mu tomado.
+func (vs *versionStore) removeIfUnreferenced(hash string) {
+	for _, records := range vs.history {
+		for _, rec := range records {
+			if rec.Hash == hash {
+				return
+			}
+		}
+	}
+	os.Remove(vs.objectPath(hash))
+}
+
+// Forget elimina el historial de un archivo y los objetos que sólo él usaba.
+func (vs *versionStore) Forget(fileName string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	records, found := vs.history[fileName]
+	if !found {
+		return
+	}
+	delete(vs.history, fileName)
+	for _, rec := range records {
+		vs.removeIfUnreferenced(rec.Hash)
+	}
+	if err := vs.saveIndex(); err != nil {
+		logEvent("VERSIONS", "ERROR", fmt.Sprintf("Falla al guardar el índice de versiones: %v", err))
+	}
+}
+
+// Rename traslada el historial de un archivo movido a su nombre nuevo.
+func (vs *versionStore) Rename(oldName, newName string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	records, found := vs.history[oldName]
+	if !found {
+		return
+	}
+	delete(vs.history, oldName)
+	vs.history[newName] = records
+	if err := vs.saveIndex(); err != nil {
+		logEvent("VERSIONS", "ERROR", fmt.Sprintf("Falla al guardar el índice de versiones: %v", err))
+	}
+}
+
+// Lookup devuelve el contenido de la versión de fileName con el reloj indicado.
+func (vs *versionStore) Lookup(fileName string, clock VectorClock) ([]byte, bool) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	for _, rec := range vs.history[fileName] {
+		if rec.Clock.Compare(clock) == clockEqual {
+			content, err := os.ReadFile(vs.objectPath(rec.Hash))
+			if err != nil {
+				return nil, false
+			}
+			return content, true
+		}
+	}
+	return nil, false
+}
+
+// History devuelve las versiones guardadas de fileName, de la más antigua a la más reciente.
+func (vs *versionStore) History(fileName string) []versionRecord {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return append([]versionRecord(nil), vs.history[fileName]...)
+}
+
+// Get devuelve el contenido de la versión número version de fileName.
+func (vs *versionStore) Get(fileName string, version int) ([]byte, bool) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	records := vs.history[fileName]
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Version == version {
+			content, err := os.ReadFile(vs.objectPath(records[i].Hash))
+			if err != nil {
+				return nil, false
+			}
+			return content, true
+		}
+	}
+	return nil, false
+}
+
+// recordCurrentVersion guarda la versión en disco de un archivo propio para
+// que sirva de base en fusiones posteriores.
+func recordCurrentVersion(fileName string, entry DirectoryEntry) {
+	content, err := os.ReadFile(localPath(fileName))
+	if err != nil {
+		return
+	}
+	versions.Record(fileName, entry, content)
+}
+
+// ownedEntry busca una entrada viva de la que este nodo es dueño. Si la entrada
+// es de otro nodo devuelve la redirección; si no existe, el NACK.
+func ownedEntry(fileName, localAddr string) (DirectoryEntry, *NetworkMessage) {
+	if nack := invalidNameResponse(fileName, localAddr); nack != nil {
+		return DirectoryEntry{}, nack
+	}
+	sharedFilesMutex.RLock()
+	entry, found := sharedFiles[fileName]
+	sharedFilesMutex.RUnlock()
+	if !found || entry.Deleted {
+		return entry, &NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte("Archivo no encontrado en el directorio."),
+			Authoritative: false,
+			SenderIP:      localAddr,
+		}
+	}
+	if entry.OwnerIP != selfAddr {
+		logEvent("SERVER", "REDIRECT", fmt.Sprintf("El historial de '%s' lo guarda su dueño. Redireccionando a %s.", fileName, entry.OwnerIP))
+		return entry, &NetworkMessage{
+			Type:    "REDIRECT_OWNER",
+			Payload: []byte(entry.OwnerIP),
+		}
+	}
+	return entry, nil
+}
+
+// handleListVersions atiende LIST_VERSIONS con las versiones guardadas de un archivo propio.
+func handleListVersions(msg NetworkMessage, localAddr string) NetworkMessage {
+	var fileName string
+	json.Unmarshal(msg.Payload, &fileName)
+	logEvent("SERVER", "LIST_VERSIONS", fmt.Sprintf("Petición del historial de '%s'.", fileName))
+	if _, response := ownedEntry(fileName, localAddr); response != nil {
+		return *response
+	}
+	payloadBytes, _ := json.Marshal(versions.History(fileName))
+	return NetworkMessage{
+		Type:          "RESPONSE_VERSIONS",
+		Payload:       payloadBytes,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleGetVersion atiende GET_VERSION con el contenido de una versión anterior.
+func handleGetVersion(msg NetworkMessage, localAddr string) NetworkMessage {
+	var req VersionRequest
+	json.Unmarshal(msg.Payload, &req)
+	logEvent("SERVER", "GET_VERSION", fmt.Sprintf("Petición de la versión %d de '%s'.", req.Version, req.FileName))
+	if _, response := ownedEntry(req.FileName, localAddr); response != nil {
+		return *response
+	}
+	content, found := versions.Get(req.FileName, req.Version)
+	if !found {
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte(fmt.Sprintf("La versión %d de '%s' no se conserva.", req.Version, req.FileName)),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+	return NetworkMessage{
+		Type:          "FILE_RESPONSE",
+		Payload:       content,
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
+
+// handleRollback atiende ROLLBACK. El contenido de la versión pedida se publica
+// como una versión nueva, de modo que el historial no se reescribe y los peers
+// la aceptan como cualquier otra modificación.
+func handleRollback(msg NetworkMessage, localAddr string) NetworkMessage {
+	var req VersionRequest
+	json.Unmarshal(msg.Payload, &req)
+	logEvent("SERVER", "ROLLBACK_REQUEST", fmt.Sprintf("Petición para revertir '%s' a la versión %d.", req.FileName, req.Version))
+	if _, response := ownedEntry(req.FileName, localAddr); response != nil {
+		return *response
+	}
+	content, found := versions.Get(req.FileName, req.Version)
+	if !found {
+		return NetworkMessage{
+			Type:          "NACK",
+			Payload:       []byte(fmt.Sprintf("La versión %d de '%s' no se conserva.", req.Version, req.FileName)),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	if reason, locked := lockConflict(req.FileName, ""); locked {
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Reversión rechazada: " + reason),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+
+	sharedFilesMutex.Lock()
+	entry, found := sharedFiles[req.FileName]
+	if !found || entry.Deleted || entry.OwnerIP != selfAddr {
+		sharedFilesMutex.Unlock()
+		return NetworkMessage{
+			Type:          "UPDATE_REJECTED",
+			Payload:       []byte("Reversión rechazada: el archivo cambió de dueño o fue eliminado."),
+			Authoritative: true,
+			SenderIP:      localAddr,
+		}
+	}
+	if err := os.WriteFile(localPath(req.FileName), content, 0644); err != nil {
+		sharedFilesMutex.Unlock()
+		logEvent("SERVER", "FILE_ERROR", fmt.Sprintf("Falla al revertir el archivo '%s': %v", req.FileName, err))
+		return NetworkMessage{
+			Type:    "NACK",
+			Payload: []byte("Error al escribir el archivo."),
+		}
+	}
+	bumpVersion(&entry, selfAddr)
+	entry.Size = int64(len(content))
+	entry.ModificationDate = fileModTime(req.FileName)
+	entry.Unavailable = false
+	assignReplicas(&entry)
+	sharedFiles[req.FileName] = entry
+	dirStore.Put(entry)
+	versions.Record(req.FileName, entry, content)
+	sharedFilesMutex.Unlock()
+
+	logEvent("SERVER", "ROLLBACK", fmt.Sprintf("'%s' revertido a la versión %d. Nueva versión: %d", req.FileName, req.Version, entry.Version))
+	go gossipProtocol.GossipUpdateAllPeers(entry)
+	go replicateFile(entry, content)
+	return NetworkMessage{
+		Type:          "UPDATE_ACK",
+		Payload:       []byte(fmt.Sprintf("Archivo revertido al contenido de la versión %d. Nueva versión: %d.", req.Version, entry.Version)),
+		Authoritative: true,
+		SenderIP:      localAddr,
+	}
+}
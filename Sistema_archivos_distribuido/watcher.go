@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchSharedDir compara periódicamente la carpeta compartida con el directorio.
+// Los archivos modificados fuera del DFS suben de versión y los que desaparecen
+// se convierten en lápidas (Deleted) que se anuncian a los peers. Un renombrado
+// se observa como la lápida del nombre viejo más una entrada nueva.
+func watchSharedDir(cfg shareConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := scanSharedDir(cfg)
+		if err != nil {
+			logEvent("WATCHER", "ERROR", fmt.Sprintf("Falla al recorrer la carpeta compartida: %v", err))
+			continue
+		}
+		for _, entry := range changed {
+			logEvent("WATCHER", "EXTERNAL_CHANGE", fmt.Sprintf("'%s' cambió en disco. Nueva versión: %d", entry.FileName, entry.Version))
+			gossipProtocol.GossipUpdateAllPeers(entry)
+		}
+
+		for _, tombstone := range tombstoneMissingOwnedFiles() {
+			logEvent("WATCHER", "EXTERNAL_DELETE", fmt.Sprintf("'%s' ya no existe en disco. Anunciando lápida versión %d.", tombstone.FileName, tombstone.Version))
+			gossipProtocol.GossipUpdateAllPeers(tombstone)
+		}
+	}
+}
+
+// tombstoneMissingOwnedFiles reemplaza por lápidas las entradas propias cuyo
+// archivo ya no existe y las devuelve para anunciarlas.
+func tombstoneMissingOwnedFiles() []DirectoryEntry {
+	var tombstones []DirectoryEntry
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	for name, entry := range sharedFiles {
+		if entry.OwnerIP != selfAddr || entry.Deleted {
+			continue
+		}
+		if _, err := os.Stat(localPath(name)); !os.IsNotExist(err) {
+			continue
+		}
+		entry.Deleted = true
+		entry.Size = 0
+		bumpVersion(&entry, selfAddr)
+		entry.ModificationDate = time.Now()
+		sharedFiles[name] = entry
+		dirStore.Put(entry)
+		tombstones = append(tombstones, entry)
+	}
+	return tombstones
+}
+
+// markMissingOwnedFiles marca como no disponibles las entradas propias cuyo
+// archivo no se encuentra en disco, y vuelve a habilitarlas si reaparece.
+func markMissingOwnedFiles() {
+	sharedFilesMutex.Lock()
+	defer sharedFilesMutex.Unlock()
+	for name, entry := range sharedFiles {
+		if entry.OwnerIP != selfAddr || entry.Deleted {
+			continue
+		}
+		_, err := os.Stat(localPath(name))
+		missing := os.IsNotExist(err)
+		if missing == entry.Unavailable {
+			continue
+		}
+		entry.Unavailable = missing
+		sharedFiles[name] = entry
+		dirStore.Put(entry)
+		if missing {
+			logEvent("SERVER_CLEANER", "FILE_UNAVAILABLE", fmt.Sprintf("El archivo propio '%s' no está en disco. Marcado como no disponible.", name))
+		} else {
+			logEvent("SERVER_CLEANER", "FILE_AVAILABLE", fmt.Sprintf("El archivo propio '%s' volvió a estar disponible.", name))
+		}
+	}
+}